@@ -0,0 +1,187 @@
+// Package cryptocell provides a cell which encrypts the contents of another cell.
+package cryptocell
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/brendoncarroll/go-state/cells"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// KeySize is the size of a key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// SaltSize is the size of the salt used to derive keys from passphrases.
+	SaltSize = 16
+
+	overhead = SaltSize + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+)
+
+// Cell seals the contents of an inner cell with XChaCha20-Poly1305.
+//
+// The inner cell contains a salt, followed by a random nonce, followed by the ciphertext.
+// The salt is used to derive the key from a passphrase, and is authenticated as additional data.
+// It is chosen randomly when the inner cell is empty, and kept when the contents change.
+type Cell struct {
+	inner     cells.Cell
+	deriveKey func(salt []byte) []byte
+
+	mu   sync.Mutex
+	salt []byte
+	aead cipher.AEAD
+}
+
+// NewWithKey returns a Cell which encrypts the contents of inner with key.
+func NewWithKey(inner cells.Cell, key [KeySize]byte) *Cell {
+	return &Cell{
+		inner: inner,
+		deriveKey: func([]byte) []byte {
+			return key[:]
+		},
+	}
+}
+
+// NewWithPassphrase returns a Cell which encrypts the contents of inner with a key derived from passphrase using Argon2id.
+func NewWithPassphrase(inner cells.Cell, passphrase []byte) *Cell {
+	passphrase = append([]byte{}, passphrase...)
+	return &Cell{
+		inner: inner,
+		deriveKey: func(salt []byte) []byte {
+			return argon2.IDKey(passphrase, salt, 1, 64*1024, 4, KeySize)
+		},
+	}
+}
+
+// ReadKeyFile reads a key from the file at p.
+// The file must contain exactly KeySize bytes, or the key encoded as hex.
+func ReadKeyFile(p string) (ret [KeySize]byte, _ error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return ret, err
+	}
+	if len(data) != KeySize {
+		data, err = hex.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil || len(data) != KeySize {
+			return ret, fmt.Errorf("key file %s must contain %d bytes, or %d hex characters", p, KeySize, 2*KeySize)
+		}
+	}
+	copy(ret[:], data)
+	return ret, nil
+}
+
+func (c *Cell) Read(ctx context.Context, buf []byte) (int, error) {
+	msg, err := cells.GetBytes(ctx, c.inner)
+	if err != nil {
+		return 0, err
+	}
+	return c.open(buf, msg)
+}
+
+func (c *Cell) CAS(ctx context.Context, actual, prev, next []byte) (bool, int, error) {
+	if len(next) > c.MaxSize() {
+		return false, 0, cells.ErrTooLarge{}
+	}
+	msg, err := cells.GetBytes(ctx, c.inner)
+	if err != nil {
+		return false, 0, err
+	}
+	n, err := c.open(actual, msg)
+	if err != nil {
+		return false, 0, err
+	}
+	if !bytes.Equal(actual[:n], prev) {
+		return false, n, nil
+	}
+	var salt []byte
+	if len(msg) > 0 {
+		salt = msg[:SaltSize]
+	}
+	msg2, err := c.seal(salt, next)
+	if err != nil {
+		return false, 0, err
+	}
+	buf := make([]byte, c.inner.MaxSize())
+	swapped, n, err := c.inner.CAS(ctx, buf, msg, msg2)
+	if err != nil {
+		return false, 0, err
+	}
+	n, err = c.open(actual, buf[:n])
+	return swapped, n, err
+}
+
+func (c *Cell) MaxSize() int {
+	return c.inner.MaxSize() - overhead
+}
+
+func (c *Cell) open(dst, msg []byte) (int, error) {
+	if len(msg) == 0 {
+		return 0, nil
+	}
+	if len(msg) < overhead {
+		return 0, errors.New("cryptocell: message too short")
+	}
+	if len(dst) < len(msg)-overhead {
+		return 0, errors.New("cryptocell: buffer too short")
+	}
+	salt := msg[:SaltSize]
+	nonce := msg[SaltSize : SaltSize+chacha20poly1305.NonceSizeX]
+	ctext := msg[SaltSize+chacha20poly1305.NonceSizeX:]
+	aead, err := c.getAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+	ptext, err := aead.Open(dst[:0], nonce, ctext, salt)
+	if err != nil {
+		return 0, fmt.Errorf("cryptocell: could not decrypt, the key may be wrong: %w", err)
+	}
+	return len(ptext), nil
+}
+
+// seal encrypts ptext with the key for salt.
+// If salt is nil, a new salt is generated.
+func (c *Cell) seal(salt, ptext []byte) ([]byte, error) {
+	msg := make([]byte, SaltSize+chacha20poly1305.NonceSizeX, overhead+len(ptext))
+	if salt == nil {
+		if _, err := rand.Read(msg[:SaltSize]); err != nil {
+			return nil, err
+		}
+	} else {
+		copy(msg, salt)
+	}
+	salt = msg[:SaltSize]
+	nonce := msg[SaltSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := c.getAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(msg, nonce, ptext, salt), nil
+}
+
+// getAEAD returns the AEAD for salt.
+// Deriving a key from a passphrase is slow, so the AEAD for the last salt is kept.
+func (c *Cell) getAEAD(salt []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aead != nil && bytes.Equal(c.salt, salt) {
+		return c.aead, nil
+	}
+	aead, err := chacha20poly1305.NewX(c.deriveKey(salt))
+	if err != nil {
+		return nil, err
+	}
+	c.salt = append(c.salt[:0], salt...)
+	c.aead = aead
+	return aead, nil
+}
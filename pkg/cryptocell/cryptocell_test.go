@@ -0,0 +1,69 @@
+package cryptocell
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/cells/celltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCell(t *testing.T) {
+	t.Run("Key", func(t *testing.T) {
+		celltest.CellTestSuite(t, func(t testing.TB) cells.Cell {
+			return NewWithKey(cells.NewMem(1<<16), [KeySize]byte{1, 2, 3})
+		})
+	})
+	t.Run("Passphrase", func(t *testing.T) {
+		celltest.CellTestSuite(t, func(t testing.TB) cells.Cell {
+			return NewWithPassphrase(cells.NewMem(1<<16), []byte("password1"))
+		})
+	})
+}
+
+func TestWrongKey(t *testing.T) {
+	ctx := context.Background()
+	inner := cells.NewMem(1 << 16)
+	c1 := NewWithPassphrase(inner, []byte("password1"))
+	require.NoError(t, cells.Apply(ctx, c1, func([]byte) ([]byte, error) {
+		return []byte("secret"), nil
+	}))
+	data, err := cells.GetBytes(ctx, inner)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "secret")
+
+	c2 := NewWithPassphrase(inner, []byte("password2"))
+	_, err = cells.GetBytes(ctx, c2)
+	require.Error(t, err)
+	data, err = cells.GetBytes(ctx, NewWithPassphrase(inner, []byte("password1")))
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(data))
+}
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key := [KeySize]byte{1, 2, 3}
+	tcs := []struct {
+		Data []byte
+		Err  bool
+	}{
+		{Data: key[:]},
+		{Data: []byte(hex.EncodeToString(key[:]) + "\n")},
+		{Data: []byte("too short"), Err: true},
+	}
+	for i, tc := range tcs {
+		p := filepath.Join(dir, "key")
+		require.NoError(t, os.WriteFile(p, tc.Data, 0o600))
+		actual, err := ReadKeyFile(p)
+		if tc.Err {
+			require.Error(t, err, "test case %d", i)
+			continue
+		}
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, key, actual, "test case %d", i)
+	}
+}
@@ -5,20 +5,27 @@ import (
 	"context"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/brendoncarroll/go-state/cells"
-	"github.com/brendoncarroll/go-state/posixfs"
 )
 
+// Cell is a cell stored in a file on the local filesystem.
+//
+// CAS holds an advisory lock on a separate lock file, next to the cell's file, between reading and writing,
+// so it is safe for multiple processes to use the same file.
+// The new contents are written to a temporary file, synced, and then renamed over the old file,
+// so the file always contains the contents from a complete CAS, even after a crash.
 type Cell struct {
-	fs posixfs.FS
 	p  string
 	mu sync.Mutex
 }
 
-func New(fs posixfs.FS, p string) *Cell {
-	return &Cell{fs: fs, p: p}
+// New returns a Cell stored in the file at p.
+func New(p string) *Cell {
+	return &Cell{p: p}
 }
 
 func (c *Cell) CAS(ctx context.Context, actual, prev, next []byte) (bool, int, error) {
@@ -27,13 +34,18 @@ func (c *Cell) CAS(ctx context.Context, actual, prev, next []byte) (bool, int, e
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	data, err := posixfs.ReadFile(ctx, c.fs, c.p)
-	if err != nil && !os.IsNotExist(err) {
+	unlock, err := c.lock(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer unlock()
+	data, err := c.readFile()
+	if err != nil {
 		return false, 0, err
 	}
 	var swapped bool
 	if bytes.Equal(data, prev) {
-		if err := posixfs.PutFile(ctx, c.fs, c.p, 0o644, bytes.NewReader(next)); err != nil {
+		if err := c.writeFile(next); err != nil {
 			return false, 0, err
 		}
 		data = next
@@ -47,11 +59,10 @@ func (c *Cell) CAS(ctx context.Context, actual, prev, next []byte) (bool, int, e
 	return swapped, copy(actual, data), nil
 }
 
+// Read does not need the lock, because the file is only ever replaced by a rename.
 func (c *Cell) Read(ctx context.Context, buf []byte) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	data, err := posixfs.ReadFile(ctx, c.fs, c.p)
-	if err != nil && !os.IsNotExist(err) {
+	data, err := c.readFile()
+	if err != nil {
 		return 0, err
 	}
 	return copy(buf, data), nil
@@ -60,3 +71,76 @@ func (c *Cell) Read(ctx context.Context, buf []byte) (int, error) {
 func (c *Cell) MaxSize() int {
 	return 1 << 16
 }
+
+// lock acquires the lock file, polling until it is available or ctx is done.
+func (c *Cell) lock(ctx context.Context) (unlock func(), _ error) {
+	f, err := os.OpenFile(c.p+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	const maxWait = 50 * time.Millisecond
+	wait := time.Millisecond
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxWait {
+			wait = maxWait
+		}
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+func (c *Cell) readFile() ([]byte, error) {
+	data, err := os.ReadFile(c.p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// writeFile atomically replaces the contents of the cell's file with data.
+func (c *Cell) writeFile(data []byte) (retErr error) {
+	dir := filepath.Dir(c.p)
+	f, err := os.CreateTemp(dir, filepath.Base(c.p)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.p); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -1,16 +1,76 @@
 package filecell
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/brendoncarroll/go-state/cells"
 	"github.com/brendoncarroll/go-state/cells/celltest"
-	"github.com/brendoncarroll/go-state/posixfs"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileCell(t *testing.T) {
 	celltest.CellTestSuite(t, func(t testing.TB) cells.Cell {
-		fs := posixfs.NewDirFS(t.TempDir())
-		return New(fs, "CELL")
+		return New(filepath.Join(t.TempDir(), "CELL"))
 	})
 }
+
+const (
+	envCellPath  = "FILECELL_TEST_PATH"
+	incrsPerProc = 50
+)
+
+// TestConcurrentProcesses forks several processes which each increment a counter in the same cell.
+// If any increment is lost, the final count will be too low.
+func TestConcurrentProcesses(t *testing.T) {
+	ctx := context.Background()
+	p := filepath.Join(t.TempDir(), "CELL")
+	const numProcs = 4
+	var cmds []*exec.Cmd
+	for i := 0; i < numProcs; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=TestIncrementProcess")
+		cmd.Env = append(os.Environ(), envCellPath+"="+p)
+		cmd.Stderr = os.Stderr
+		require.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		require.NoError(t, cmd.Wait())
+	}
+	buf := make([]byte, 64)
+	n, err := New(p).Read(ctx, buf)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint(numProcs*incrsPerProc), string(buf[:n]))
+}
+
+// TestIncrementProcess is run in a child process by TestConcurrentProcesses.
+func TestIncrementProcess(t *testing.T) {
+	p := os.Getenv(envCellPath)
+	if p == "" {
+		t.Skip("only run by TestConcurrentProcesses")
+	}
+	ctx := context.Background()
+	c := New(p)
+	buf := make([]byte, c.MaxSize())
+	n, err := c.Read(ctx, buf)
+	require.NoError(t, err)
+	// cells.Apply gives up after a few attempts, so retry until each increment succeeds.
+	for i := 0; i < incrsPerProc; {
+		var x int
+		if n > 0 {
+			x, err = strconv.Atoi(string(buf[:n]))
+			require.NoError(t, err)
+		}
+		var swapped bool
+		swapped, n, err = c.CAS(ctx, buf, buf[:n], []byte(strconv.Itoa(x+1)))
+		require.NoError(t, err)
+		if swapped {
+			i++
+		}
+	}
+}
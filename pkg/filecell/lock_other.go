@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package filecell
+
+import "os"
+
+// On these platforms, flock is not available, so CAS is only safe within a single process.
+
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+func syncDir(p string) error {
+	return nil
+}
@@ -1,26 +1,54 @@
 package hcorpus
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 
+	"github.com/blobcache/glfs"
 	"github.com/brendoncarroll/go-state/cadata"
 	"github.com/gotvc/got/pkg/gotfs"
 	"github.com/gotvc/got/pkg/gotkv"
 )
 
+// MaxDataSize is the largest data which is stored in the corpus itself.
+// Larger data is stored as a GLFS blob, and the corpus holds a reference to it.
 const MaxDataSize = 4096
 
+// Root is the root of a corpus.
+// The zero Root is an empty corpus which has not been stored yet; it can be read without reading the store,
+// but it must be created with NewEmpty before anything is added to it.
 type Root gotfs.Root
 
+func (r Root) isZero() bool {
+	return RootsEqual(gotkv.Root(r), gotkv.Root{})
+}
+
 type Operator struct {
-	gotkv gotkv.Operator
+	gotkv    gotkv.Operator
+	validate func([]byte) error
 }
 
-func New() *Operator {
-	return &Operator{
+type Option func(o *Operator)
+
+// WithValidator sets a function which is called to check data before it is posted.
+func WithValidator(fn func(data []byte) error) Option {
+	return func(o *Operator) {
+		o.validate = fn
+	}
+}
+
+func New(opts ...Option) *Operator {
+	o := &Operator{
 		gotkv: gotkv.NewOperator(1<<13, 1<<20),
 	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
 }
 
 func (o *Operator) NewEmpty(ctx context.Context, s cadata.Store) (*Root, error) {
@@ -31,12 +59,27 @@ func (o *Operator) NewEmpty(ctx context.Context, s cadata.Store) (*Root, error)
 	return (*Root)(r), nil
 }
 
+// Post adds data to the corpus, and returns its ID, which is the hash of data.
+// Data larger than MaxDataSize is stored out-of-line in s, but has an ID in the same way.
 func (o *Operator) Post(ctx context.Context, s cadata.Store, x Root, data []byte) (ID, *Root, error) {
-	if len(data) > MaxDataSize {
-		return ID{}, nil, errors.New("value too large")
+	if o.validate != nil {
+		if err := o.validate(data); err != nil {
+			return ID{}, nil, err
+		}
 	}
 	id := Hash(data)
-	root, err := o.gotkv.Put(ctx, s, gotkv.Root(x), id[:], data)
+	key, value := id[:], data
+	if len(data) > MaxDataSize {
+		ref, err := glfs.PostBlob(ctx, s, bytes.NewReader(data))
+		if err != nil {
+			return ID{}, nil, err
+		}
+		if value, err = json.Marshal(ref); err != nil {
+			return ID{}, nil, err
+		}
+		key = makeRefKey(id)
+	}
+	root, err := o.gotkv.Put(ctx, s, gotkv.Root(x), key, value)
 	if err != nil {
 		return ID{}, nil, err
 	}
@@ -44,11 +87,70 @@ func (o *Operator) Post(ctx context.Context, s cadata.Store, x Root, data []byte
 }
 
 func (o *Operator) Get(ctx context.Context, s cadata.Store, x Root, fp ID) ([]byte, error) {
-	return o.gotkv.Get(ctx, s, gotkv.Root(x), fp[:])
+	if x.isZero() {
+		return nil, gotkv.ErrKeyNotFound
+	}
+	data, err := o.gotkv.Get(ctx, s, gotkv.Root(x), fp[:])
+	if !errors.Is(err, gotkv.ErrKeyNotFound) {
+		return data, err
+	}
+	refData, err2 := o.gotkv.Get(ctx, s, gotkv.Root(x), makeRefKey(fp))
+	if errors.Is(err2, gotkv.ErrKeyNotFound) {
+		return nil, err
+	} else if err2 != nil {
+		return nil, err2
+	}
+	var ref glfs.Ref
+	if err := json.Unmarshal(refData, &ref); err != nil {
+		return nil, err
+	}
+	r, err := glfs.GetBlob(ctx, s, ref)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	if Hash(data) != fp {
+		return nil, fmt.Errorf("data for %v stored out-of-line has the wrong hash", fp)
+	}
+	return data, nil
+}
+
+// PutMeta stores metadata about the entry for id.
+// It is stored beside the entry, and deleted with it.
+func (o *Operator) PutMeta(ctx context.Context, s cadata.Store, x Root, id ID, data []byte) (*Root, error) {
+	if len(data) > MaxDataSize {
+		return nil, errors.New("value too large")
+	}
+	root, err := o.gotkv.Put(ctx, s, gotkv.Root(x), makeMetaKey(id), data)
+	if err != nil {
+		return nil, err
+	}
+	return (*Root)(root), nil
+}
+
+// GetMeta returns the metadata stored with PutMeta for id.
+func (o *Operator) GetMeta(ctx context.Context, s cadata.Store, x Root, id ID) ([]byte, error) {
+	if x.isZero() {
+		return nil, gotkv.ErrKeyNotFound
+	}
+	return o.gotkv.Get(ctx, s, gotkv.Root(x), makeMetaKey(id))
 }
 
 func (o *Operator) ForEach(ctx context.Context, s cadata.Store, x Root, span gotkv.Span, fn func(fp ID) error) error {
+	if x.isZero() {
+		return nil
+	}
 	return o.gotkv.ForEach(ctx, s, gotkv.Root(x), span, func(ent gotkv.Entry) error {
+		switch {
+		case len(ent.Key) == len(ID{}):
+		case bytes.Equal(ent.Key, makeRefKey(IDFromBytes(ent.Key))):
+			// data stored out-of-line
+		default:
+			// metadata
+			return nil
+		}
 		id := IDFromBytes(ent.Key)
 		return fn(id)
 	})
@@ -56,5 +158,70 @@ func (o *Operator) ForEach(ctx context.Context, s cadata.Store, x Root, span got
 
 func (o *Operator) Delete(ctx context.Context, s cadata.Store, x Root, id ID) (*Root, error) {
 	y, err := o.gotkv.Delete(ctx, s, gotkv.Root(x), id[:])
+	if err != nil {
+		return nil, err
+	}
+	if y, err = o.gotkv.Delete(ctx, s, *y, makeRefKey(id)); err != nil {
+		return nil, err
+	}
+	y, err = o.gotkv.Delete(ctx, s, *y, makeMetaKey(id))
 	return (*Root)(y), err
 }
+
+// makeRefKey returns the key for the reference to data stored out-of-line, which sorts after the metadata for id.
+func makeRefKey(id ID) []byte {
+	return append(id[:], 0x01)
+}
+
+// makeMetaKey returns the key for the metadata of id, which sorts directly after id.
+func makeMetaKey(id ID) []byte {
+	return append(id[:], 0x00)
+}
+
+// Diff calls fn with each ID which is only in one of left and right.
+// The data for an ID never changes, so IDs in both are the same.
+// Parts of the corpus shared by left and right are skipped without being read, see DiffTrees.
+func (o *Operator) Diff(ctx context.Context, s cadata.Store, left, right Root, fn func(id ID, inLeft, inRight bool) error) error {
+	var roots [2]*gotkv.Root
+	for i, root := range []Root{left, right} {
+		if !root.isZero() {
+			root := gotkv.Root(root)
+			roots[i] = &root
+		}
+	}
+	// all the keys for an ID are adjacent, so its data can be found in each side before moving on to the next ID.
+	var current *ID
+	var inLeft, inRight bool
+	flush := func() error {
+		if current == nil || inLeft == inRight {
+			return nil
+		}
+		return fn(*current, inLeft, inRight)
+	}
+	if err := DiffTrees(ctx, &o.gotkv, s, roots[0], roots[1], func(key, lv, rv []byte) error {
+		if len(key) < len(ID{}) {
+			return nil
+		}
+		id := IDFromBytes(key)
+		if len(key) != len(ID{}) && !bytes.Equal(key, makeRefKey(id)) {
+			return nil
+		}
+		if current == nil || *current != id {
+			if err := flush(); err != nil {
+				return err
+			}
+			current, inLeft, inRight = &id, false, false
+		}
+		inLeft = inLeft || lv != nil
+		inRight = inRight || rv != nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// RootsEqual returns true if a and b refer to the same gotkv tree.
+func RootsEqual(a, b gotkv.Root) bool {
+	return a.Ref == b.Ref && a.Depth == b.Depth && bytes.Equal(a.First, b.First)
+}
@@ -1,11 +1,14 @@
 package hcorpus
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/brendoncarroll/go-state/cadata"
 	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/gotvc/got/pkg/gotkv"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,3 +34,95 @@ func setup(t testing.TB) (*Operator, cadata.Store) {
 	s := cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
 	return op, s
 }
+
+func TestMeta(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	id, root, err := op.Post(ctx, s, *root, []byte("my test string"))
+	require.NoError(t, err)
+	root, err = op.PutMeta(ctx, s, *root, id, []byte("metadata"))
+	require.NoError(t, err)
+	meta, err := op.GetMeta(ctx, s, *root, id)
+	require.NoError(t, err)
+	require.Equal(t, "metadata", string(meta))
+
+	var ids []ID
+	require.NoError(t, op.ForEach(ctx, s, *root, gotkv.TotalSpan(), func(id ID) error {
+		ids = append(ids, id)
+		return nil
+	}))
+	require.Equal(t, []ID{id}, ids)
+}
+
+func TestValidator(t *testing.T) {
+	ctx := context.Background()
+	_, s := setup(t)
+	op := New(WithValidator(func(data []byte) error {
+		if string(data) != "valid" {
+			return errors.New("invalid")
+		}
+		return nil
+	}))
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	_, _, err = op.Post(ctx, s, *root, []byte("valid"))
+	require.NoError(t, err)
+	_, _, err = op.Post(ctx, s, *root, []byte("not valid"))
+	require.Error(t, err)
+}
+
+func TestLargeData(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), MaxDataSize)
+	smallID, root, err := op.Post(ctx, s, *root, small)
+	require.NoError(t, err)
+	largeID, root, err := op.Post(ctx, s, *root, large)
+	require.NoError(t, err)
+	require.Equal(t, Hash(large), largeID)
+
+	data, err := op.Get(ctx, s, *root, largeID)
+	require.NoError(t, err)
+	require.Equal(t, large, data)
+	var ids []ID
+	require.NoError(t, op.ForEach(ctx, s, *root, gotkv.TotalSpan(), func(id ID) error {
+		ids = append(ids, id)
+		return nil
+	}))
+	require.ElementsMatch(t, []ID{smallID, largeID}, ids)
+
+	root, err = op.Delete(ctx, s, *root, largeID)
+	require.NoError(t, err)
+	_, err = op.Get(ctx, s, *root, largeID)
+	require.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	base, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	shared, base, err := op.Post(ctx, s, *base, []byte("shared"))
+	require.NoError(t, err)
+	onlyA, a, err := op.Post(ctx, s, *base, []byte("a"))
+	require.NoError(t, err)
+	a, err = op.PutMeta(ctx, s, *a, shared, []byte("metadata"))
+	require.NoError(t, err)
+	onlyB, b, err := op.Post(ctx, s, *base, bytes.Repeat([]byte("b"), MaxDataSize+1))
+	require.NoError(t, err)
+
+	diffs := map[ID][2]bool{}
+	require.NoError(t, op.Diff(ctx, s, *a, *b, func(id ID, inA, inB bool) error {
+		diffs[id] = [2]bool{inA, inB}
+		return nil
+	}))
+	require.Equal(t, map[ID][2]bool{
+		onlyA: {true, false},
+		onlyB: {false, true},
+	}, diffs)
+}
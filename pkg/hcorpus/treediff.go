@@ -0,0 +1,112 @@
+package hcorpus
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gdat"
+	"github.com/gotvc/got/pkg/gotkv"
+)
+
+// DiffTrees calls fn with each key whose value differs between the gotkv trees left and right, in key order.
+// The value is nil on the side which does not have the key. A nil Root is an empty tree.
+// Both trees are walked together, and subtrees which have the same ref in left and right are skipped without being read.
+func DiffTrees(ctx context.Context, op *gotkv.Operator, s cadata.Store, left, right *gotkv.Root, fn func(key, left, right []byte) error) error {
+	var sides [2][]treeItem
+	for i, root := range []*gotkv.Root{left, right} {
+		if root != nil {
+			sides[i] = []treeItem{{root: root}}
+		}
+	}
+	// expand replaces the subtree at the front of a side with its children.
+	expand := func(i int) error {
+		items, err := readTreeNode(ctx, op, s, *sides[i][0].root)
+		if err != nil {
+			return err
+		}
+		sides[i] = append(items, sides[i][1:]...)
+		return nil
+	}
+	for len(sides[0]) > 0 || len(sides[1]) > 0 {
+		var err error
+		switch {
+		case len(sides[1]) == 0 || len(sides[0]) > 0 && sides[0][0].before(sides[1][0]):
+			if sides[0][0].root != nil {
+				err = expand(0)
+			} else {
+				err = fn(sides[0][0].key, sides[0][0].value, nil)
+				sides[0] = sides[0][1:]
+			}
+		case len(sides[0]) == 0 || sides[1][0].before(sides[0][0]):
+			if sides[1][0].root != nil {
+				err = expand(1)
+			} else {
+				err = fn(sides[1][0].key, nil, sides[1][0].value)
+				sides[1] = sides[1][1:]
+			}
+		default:
+			a, b := sides[0][0], sides[1][0]
+			switch {
+			case a.root != nil && b.root != nil && RootsEqual(*a.root, *b.root):
+				sides[0], sides[1] = sides[0][1:], sides[1][1:]
+			case a.root != nil && (b.root == nil || a.root.Depth >= b.root.Depth):
+				err = expand(0)
+			case b.root != nil:
+				err = expand(1)
+			default:
+				if !bytes.Equal(a.value, b.value) {
+					err = fn(a.key, a.value, b.value)
+				}
+				sides[0], sides[1] = sides[0][1:], sides[1][1:]
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeItem is either a subtree, or an entry if root is nil.
+type treeItem struct {
+	root       *gotkv.Root
+	key, value []byte
+}
+
+// firstKey is the smallest key which could be in the item.
+func (x treeItem) firstKey() []byte {
+	if x.root != nil {
+		return x.root.First
+	}
+	return x.key
+}
+
+// before returns true if everything in x comes before everything in y.
+// Only an entry can be entirely before something, a subtree could always contain keys after y's first key.
+func (x treeItem) before(y treeItem) bool {
+	return x.root == nil && bytes.Compare(x.key, y.firstKey()) < 0
+}
+
+// readTreeNode reads the node at root.
+// Nodes at depth 0 hold entries, the other nodes hold an entry for each child, from its first key to its ref.
+func readTreeNode(ctx context.Context, op *gotkv.Operator, s cadata.Store, root gotkv.Root) ([]treeItem, error) {
+	var items []treeItem
+	node := gotkv.Root{Ref: root.Ref, First: root.First}
+	if err := op.ForEach(ctx, s, node, gotkv.TotalSpan(), func(ent gotkv.Entry) error {
+		key := append([]byte{}, ent.Key...)
+		if root.Depth == 0 {
+			items = append(items, treeItem{key: key, value: append([]byte{}, ent.Value...)})
+			return nil
+		}
+		ref, err := gdat.ParseRef(ent.Value)
+		if err != nil {
+			return err
+		}
+		items = append(items, treeItem{root: &gotkv.Root{Ref: *ref, Depth: root.Depth - 1, First: key}})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,75 @@
+package hcorpus
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/gotvc/got/pkg/gotkv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTrees(t *testing.T) {
+	ctx := context.Background()
+	s := &countingStore{Store: cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)}
+	op := gotkv.NewOperator(1<<13, 1<<20)
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%06d", i))
+	}
+	var ents []gotkv.Entry
+	for i := 0; i < 50000; i++ {
+		ents = append(ents, gotkv.Entry{Key: key(i), Value: []byte(fmt.Sprint(i))})
+	}
+	a, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	a, err = op.Mutate(ctx, s, *a, gotkv.Mutation{Span: gotkv.TotalSpan(), Entries: ents})
+	require.NoError(t, err)
+	b, err := op.Put(ctx, s, *a, key(5000), []byte("changed"))
+	require.NoError(t, err)
+	b, err = op.Delete(ctx, s, *b, key(7000))
+	require.NoError(t, err)
+	b, err = op.Put(ctx, s, *b, []byte("key-new"), []byte("new"))
+	require.NoError(t, err)
+
+	type change struct {
+		Key, Left, Right string
+	}
+	diff := func(left, right *gotkv.Root) (ret []change) {
+		require.NoError(t, DiffTrees(ctx, &op, s, left, right, func(key, l, r []byte) error {
+			ret = append(ret, change{string(key), string(l), string(r)})
+			return nil
+		}))
+		return ret
+	}
+	s.gets = 0
+	require.Equal(t, []change{
+		{string(key(5000)), "5000", "changed"},
+		{string(key(7000)), "7000", ""},
+		{"key-new", "", "new"},
+	}, diff(a, b))
+	// only the nodes on the paths to the changes are read.
+	diffGets := s.gets
+	s.gets = 0
+	for _, root := range []*gotkv.Root{a, b} {
+		require.NoError(t, op.ForEach(ctx, s, *root, gotkv.TotalSpan(), func(gotkv.Entry) error { return nil }))
+	}
+	require.Less(t, 10*diffGets, s.gets)
+
+	require.Len(t, diff(a, a), 0)
+	require.Len(t, diff(a, nil), len(ents))
+	require.Len(t, diff(nil, b), len(ents))
+}
+
+// countingStore counts the calls to Get
+type countingStore struct {
+	cadata.Store
+	gets int64
+}
+
+func (s *countingStore) Get(ctx context.Context, id cadata.ID, buf []byte) (int, error) {
+	atomic.AddInt64(&s.gets, 1)
+	return s.Store.Get(ctx, id, buf)
+}
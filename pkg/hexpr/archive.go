@@ -0,0 +1,218 @@
+package hexpr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveZip = ArchiveFormat("zip")
+	ArchiveTar = ArchiveFormat("tar")
+)
+
+// ArchiveExpr refers to the file at Path in the archive which is the value of Base.
+// Archives can contain more than one file at the same path, Index is the number of files at Path which come before it.
+type ArchiveExpr struct {
+	Base   hcorpus.ID    `json:"base"`
+	Format ArchiveFormat `json:"format"`
+	Path   string        `json:"path"`
+	Index  int           `json:"index,omitempty"`
+}
+
+func NewArchiveMember(base hcorpus.ID, format ArchiveFormat, p string) Expr {
+	return Expr{
+		Archive: &ArchiveExpr{Base: base, Format: format, Path: p},
+	}
+}
+
+// DetectArchive returns the format of the archive in r, or false if r is not an archive.
+func DetectArchive(r io.ReaderAt) (ArchiveFormat, bool) {
+	var buf [512]byte
+	n, _ := r.ReadAt(buf[:], 0)
+	switch {
+	case n >= 4 && bytes.Equal(buf[:4], []byte("PK\x03\x04")):
+		return ArchiveZip, true
+	case n == 512 && isTarHeader(buf):
+		return ArchiveTar, true
+	default:
+		return "", false
+	}
+}
+
+// isTarHeader returns true if block has a valid tar header checksum.
+// The checksum is used rather than the ustar magic, which archives in the original (V7) format do not have.
+func isTarHeader(block [512]byte) bool {
+	if block[0] == 0 {
+		return false
+	}
+	field := bytes.TrimRight(bytes.TrimLeft(block[148:156], " "), " \x00")
+	expected, err := strconv.ParseUint(string(field), 8, 32)
+	if err != nil {
+		return false
+	}
+	var sum uint64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += uint64(b)
+	}
+	return sum == expected
+}
+
+// ListArchive returns the paths of the regular files in the archive in r, in the order they appear.
+// A path is listed once for each file at that path.
+func ListArchive(format ArchiveFormat, r io.ReaderAt) ([]string, error) {
+	var paths []string
+	switch format {
+	case ArchiveZip:
+		zr, err := newZipReader(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Mode().IsRegular() {
+				paths = append(paths, f.Name)
+			}
+		}
+	case ArchiveTar:
+		tr := tar.NewReader(io.NewSectionReader(r, 0, math.MaxInt64))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+				paths = append(paths, hdr.Name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	return paths, nil
+}
+
+// openArchiveMember returns the contents of the file at p in the archive in r, skipping the first index files at p.
+// Files which are stored uncompressed are read directly from r.
+func openArchiveMember(format ArchiveFormat, r io.ReaderAt, p string, index int) (io.ReaderAt, error) {
+	skip := index
+	switch format {
+	case ArchiveZip:
+		zr, err := newZipReader(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Name != p || !f.Mode().IsRegular() {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if f.Method == zip.Store {
+				offset, err := f.DataOffset()
+				if err != nil {
+					return nil, err
+				}
+				return io.NewSectionReader(r, offset, int64(f.UncompressedSize64)), nil
+			}
+			return &streamReaderAt{open: f.Open}, nil
+		}
+	case ArchiveTar:
+		sr := io.NewSectionReader(r, 0, math.MaxInt64)
+		tr := tar.NewReader(sr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if hdr.Name != p || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			// the reader is positioned at the start of the file's data.
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			return io.NewSectionReader(r, offset, hdr.Size), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	return nil, fmt.Errorf("%s archive has no file %q (index %d)", format, p, index)
+}
+
+func newZipReader(r io.ReaderAt) (*zip.Reader, error) {
+	size, err := sizeOf(r)
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(r, size)
+}
+
+// sizeOf returns the number of bytes in r
+func sizeOf(r io.ReaderAt) (int64, error) {
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return s.Size(), nil
+	}
+	return io.Copy(ioutil.Discard, io.NewSectionReader(r, 0, math.MaxInt64))
+}
+
+// streamReaderAt is an io.ReaderAt over a stream which can only be read sequentially.
+// Reading before the current position reopens the stream.
+type streamReaderAt struct {
+	open func() (io.ReadCloser, error)
+
+	mu  sync.Mutex
+	rc  io.ReadCloser
+	pos int64
+}
+
+func (s *streamReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rc == nil || offset < s.pos {
+		if s.rc != nil {
+			s.rc.Close()
+		}
+		rc, err := s.open()
+		if err != nil {
+			s.rc = nil
+			return 0, err
+		}
+		s.rc, s.pos = rc, 0
+	}
+	if offset > s.pos {
+		n, err := io.CopyN(ioutil.Discard, s.rc, offset-s.pos)
+		s.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(s.rc, p)
+	s.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package hexpr
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+)
+
+type Codec string
+
+const (
+	CodecGzip  = Codec("gzip")
+	CodecBzip2 = Codec("bzip2")
+	CodecZlib  = Codec("zlib")
+)
+
+// DecompressExpr evaluates to the value of Base, decompressed with Codec.
+type DecompressExpr struct {
+	Base  hcorpus.ID `json:"base"`
+	Codec Codec      `json:"codec"`
+}
+
+func NewDecompress(base hcorpus.ID, codec Codec) Expr {
+	return Expr{
+		Decompress: &DecompressExpr{Base: base, Codec: codec},
+	}
+}
+
+// DetectCompression returns the codec the data in r is compressed with, or false if it is not compressed.
+// Only the first 512 bytes of r are read.
+func DetectCompression(r io.ReaderAt) (Codec, bool) {
+	var buf [512]byte
+	n, _ := r.ReadAt(buf[:], 0)
+	switch {
+	case n >= 2 && buf[0] == 0x1f && buf[1] == 0x8b:
+		return CodecGzip, true
+	case n >= 4 && bytes.Equal(buf[:3], []byte("BZh")) && buf[3] >= '1' && buf[3] <= '9':
+		return CodecBzip2, true
+	case n >= 2 && buf[0] == 0x78 && (uint16(buf[0])<<8|uint16(buf[1]))%31 == 0:
+		// deflate with a 32K window, and a valid header checksum.
+		// text can look like that, so the start of the stream must also decompress.
+		zr, err := zlib.NewReader(bytes.NewReader(buf[:n]))
+		if err == nil {
+			_, err = zr.Read(make([]byte, 1))
+		}
+		return CodecZlib, err == nil || err == io.EOF || (err == io.ErrUnexpectedEOF && n == len(buf))
+	default:
+		return "", false
+	}
+}
+
+// newDecompressor returns the decompressed contents of r.
+// The stream is decompressed again from the start when reading backwards.
+func newDecompressor(codec Codec, r io.ReaderAt) (io.ReaderAt, error) {
+	var open func(io.Reader) (io.ReadCloser, error)
+	switch codec {
+	case CodecGzip:
+		open = func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}
+	case CodecBzip2:
+		open = func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		}
+	case CodecZlib:
+		open = zlib.NewReader
+	default:
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+	return &streamReaderAt{open: func() (io.ReadCloser, error) {
+		return open(io.NewSectionReader(r, 0, math.MaxInt64))
+	}}, nil
+}
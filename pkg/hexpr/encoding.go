@@ -0,0 +1,339 @@
+package hexpr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/blobcache/glfs"
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotfs"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+// CurrentVersion is the version of the encoding produced by Marshal.
+//
+// Version 0 is the JSON encoding of Expr, which was used before the binary encoding.
+// It can still be parsed, but it is never produced, see VersionOf.
+//
+// Version 1 is a version byte followed by the encoding of the expression.
+// An expression is encoded as a byte for its kind, followed by its fields in order.
+// Strings and byte slices are prefixed with their length as a uvarint, integers are varints, and IDs are 32 bytes.
+// glfs.Refs, gotfs.Roots and label queries are encoded field by field, see appendGLFSRef, appendGotFSRoot and labels.MarshalQuery.
+const CurrentVersion = 1
+
+const (
+	tagEmpty      = 0
+	tagGLFS       = 1
+	tagGotFS      = 2
+	tagSlice      = 3
+	tagArchive    = 4
+	tagDecompress = 5
+	tagList       = 6
+	tagSet        = 7
+	tagQuery      = 8
+	tagEval       = 9
+)
+
+// VersionOf returns the version of the encoding of data.
+func VersionOf(data []byte) (int, error) {
+	switch {
+	case len(data) == 0:
+		return 0, errors.New("empty data")
+	case data[0] == '{':
+		return 0, nil
+	case data[0] == CurrentVersion:
+		return CurrentVersion, nil
+	default:
+		return 0, fmt.Errorf("unknown expression encoding version %d", data[0])
+	}
+}
+
+// Marshal returns the canonical encoding of e.
+// The ID of e is the hash of its encoding.
+func Marshal(e Expr) []byte {
+	return appendExpr([]byte{CurrentVersion}, e)
+}
+
+// ParseExpr parses an expression encoded with Marshal, or with the legacy JSON encoding.
+// It returns an error if the expression is not valid.
+func ParseExpr(x []byte) (*Expr, error) {
+	v, err := VersionOf(x)
+	if err != nil {
+		return nil, err
+	}
+	var e Expr
+	if v == 0 {
+		if err := json.Unmarshal(x, &e); err != nil {
+			return nil, err
+		}
+	} else {
+		r := &exprReader{data: x[1:]}
+		e = r.readExpr(0)
+		if r.err == nil && len(r.data) > 0 {
+			r.err = fmt.Errorf("%d extra bytes after expression", len(r.data))
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if err := e.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	return &e, nil
+}
+
+// ValidateData returns an error if data is not a valid encoded expression.
+func ValidateData(data []byte) error {
+	_, err := ParseExpr(data)
+	return err
+}
+
+func appendExpr(out []byte, e Expr) []byte {
+	switch {
+	case e.GLFS != nil:
+		out = append(out, tagGLFS)
+		out = appendGLFSRef(out, *e.GLFS)
+	case e.GotFS != nil:
+		out = append(out, tagGotFS)
+		out = appendGotFSRoot(out, e.GotFS.Root)
+		out = appendLP(out, []byte(e.GotFS.Path))
+	case e.Slice != nil:
+		out = append(out, tagSlice)
+		out = append(out, e.Slice.Base[:]...)
+		out = appendVarint(out, e.Slice.Offset)
+		out = appendVarint(out, e.Slice.Length)
+	case e.Archive != nil:
+		out = append(out, tagArchive)
+		out = append(out, e.Archive.Base[:]...)
+		out = appendLP(out, []byte(e.Archive.Format))
+		out = appendLP(out, []byte(e.Archive.Path))
+		out = appendVarint(out, int64(e.Archive.Index))
+	case e.Decompress != nil:
+		out = append(out, tagDecompress)
+		out = append(out, e.Decompress.Base[:]...)
+		out = appendLP(out, []byte(e.Decompress.Codec))
+	case e.List != nil:
+		out = append(out, tagList)
+		out = appendIDs(out, *e.List)
+	case e.Set != nil:
+		out = append(out, tagSet)
+		out = appendIDs(out, *e.Set)
+	case e.Query != nil:
+		out = append(out, tagQuery)
+		out = appendLP(out, []byte(e.Query.Name))
+		out = appendLP(out, []byte(e.Query.Index))
+		out = appendLP(out, labels.MarshalQuery(e.Query.Query))
+	case e.Eval != nil:
+		out = append(out, tagEval)
+		out = appendExpr(out, *e.Eval)
+	default:
+		out = append(out, tagEmpty)
+	}
+	return out
+}
+
+func appendLP(out, x []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(x)))
+	out = append(out, buf[:n]...)
+	return append(out, x...)
+}
+
+func appendVarint(out []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	return append(out, buf[:n]...)
+}
+
+func appendIDs(out []byte, ids []hcorpus.ID) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(ids)))
+	out = append(out, buf[:n]...)
+	for _, id := range ids {
+		out = append(out, id[:]...)
+	}
+	return out
+}
+
+// appendGLFSRef appends the fields of ref; the type is length-prefixed, and the CID and DEK are fixed length.
+func appendGLFSRef(out []byte, ref glfs.Ref) []byte {
+	out = appendLP(out, []byte(ref.Type))
+	out = append(out, ref.CID[:]...)
+	return append(out, ref.DEK[:]...)
+}
+
+// appendGotFSRoot appends the fields of root; the CID and DEK of its ref, its depth as a byte, and its first key length-prefixed.
+func appendGotFSRoot(out []byte, root gotfs.Root) []byte {
+	out = append(out, root.Ref.CID[:]...)
+	out = append(out, root.Ref.DEK[:]...)
+	out = append(out, root.Depth)
+	return appendLP(out, root.First)
+}
+
+// exprReader parses the binary encoding.
+// The first error is kept in err, and later reads return zero values.
+type exprReader struct {
+	data []byte
+	err  error
+}
+
+func (r *exprReader) readExpr(depth int) (e Expr) {
+	if depth > DefaultMaxDepth && r.err == nil {
+		r.err = fmt.Errorf("expression is nested more than %d deep", DefaultMaxDepth)
+	}
+	switch tag := r.readByte(); tag {
+	case tagEmpty:
+	case tagGLFS:
+		ref := r.readGLFSRef()
+		e.GLFS = &ref
+	case tagGotFS:
+		var x GotFSExpr
+		x.Root = r.readGotFSRoot()
+		x.Path = string(r.readLP())
+		e.GotFS = &x
+	case tagSlice:
+		e.Slice = &SliceExpr{Base: r.readID(), Offset: r.readVarint(), Length: r.readVarint()}
+	case tagArchive:
+		e.Archive = &ArchiveExpr{
+			Base:   r.readID(),
+			Format: ArchiveFormat(r.readLP()),
+			Path:   string(r.readLP()),
+			Index:  int(r.readVarint()),
+		}
+	case tagDecompress:
+		e.Decompress = &DecompressExpr{Base: r.readID(), Codec: Codec(r.readLP())}
+	case tagList:
+		l := ListExpr(r.readIDs())
+		e.List = &l
+	case tagSet:
+		s := SetExpr(r.readIDs())
+		e.Set = &s
+	case tagQuery:
+		var q QueryExpr
+		q.Name = string(r.readLP())
+		q.Index = string(r.readLP())
+		q.Query = r.readQuery()
+		e.Query = &q
+	case tagEval:
+		x := r.readExpr(depth + 1)
+		e.Eval = &x
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unknown expression tag %d", tag)
+		}
+	}
+	if r.err != nil {
+		return Expr{}
+	}
+	return e
+}
+
+func (r *exprReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 1 {
+		r.err = errors.New("unexpected end of expression")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *exprReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid uvarint in expression")
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *exprReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid varint in expression")
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *exprReader) readN(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = errors.New("unexpected end of expression")
+		return nil
+	}
+	x := r.data[:n]
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *exprReader) readLP() []byte {
+	return r.readN(r.readUvarint())
+}
+
+func (r *exprReader) readID() (id hcorpus.ID) {
+	copy(id[:], r.readN(uint64(len(id))))
+	return id
+}
+
+func (r *exprReader) readIDs() []hcorpus.ID {
+	n := r.readUvarint()
+	if r.err == nil && n > uint64(len(r.data))/32 {
+		r.err = errors.New("unexpected end of expression")
+	}
+	if r.err != nil {
+		return nil
+	}
+	ids := make([]hcorpus.ID, n)
+	for i := range ids {
+		ids[i] = r.readID()
+	}
+	return ids
+}
+
+func (r *exprReader) readGLFSRef() (ref glfs.Ref) {
+	ref.Type = glfs.Type(r.readLP())
+	ref.CID = cadata.ID(r.readID())
+	copy(ref.DEK[:], r.readN(uint64(len(ref.DEK))))
+	return ref
+}
+
+func (r *exprReader) readGotFSRoot() (root gotfs.Root) {
+	root.Ref.CID = cadata.ID(r.readID())
+	copy(root.Ref.DEK[:], r.readN(uint64(len(root.Ref.DEK))))
+	root.Depth = r.readByte()
+	if first := r.readLP(); len(first) > 0 {
+		root.First = append([]byte{}, first...)
+	}
+	return root
+}
+
+func (r *exprReader) readQuery() labels.Query {
+	data := r.readLP()
+	if r.err != nil {
+		return labels.Query{}
+	}
+	q, err := labels.ParseQuery(data)
+	if err != nil {
+		r.err = err
+		return labels.Query{}
+	}
+	return *q
+}
@@ -0,0 +1,116 @@
+package hexpr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blobcache/glfs"
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+// TestGoldenIDs pins the IDs of expressions.
+// If this test fails, the encoding has changed, and every existing ID would change with it.
+// Change the encoding by adding a new version instead.
+func TestGoldenIDs(t *testing.T) {
+	a := hcorpus.Hash([]byte("a"))
+	b := hcorpus.Hash([]byte("b"))
+	ref, root := testRefs(a, b)
+	tcs := []struct {
+		Expr Expr
+		ID   string
+	}{
+		{NewGLFS(ref), "2c5f9e149c2e68ded987a9072fc1e5989c572a08cf6247aa0244dee97b7eda74"},
+		{NewGotFS(root, "dir/file.txt"), "2aa9820a0c04f3a637f3387ae7182a726f6a3d4849c4c9d4510a3218466d75c1"},
+		{NewSlice(a, 10, 20), "fc0cdc31a1218220556e3b60e089c64e57327c321927ddc65f1b0f01ffad3b69"},
+		{NewArchiveMember(a, ArchiveZip, "dir/file.txt"), "063872d8fe3612fd01fae7ffc0c2746cb8ae8906f3aa28cff0c3f0fd0581505c"},
+		{NewDecompress(a, CodecGzip), "4b30ae33ad958ad62800db892942650dfd21ff4493bd07d989b32d62ec67c022"},
+		{NewList([]hcorpus.ID{b, a}), "1563d3b7b320b4185ae8c28974789c7acc09f7ae6712ecc1dedde5e271a63afb"},
+		{NewSet([]hcorpus.ID{b, a}), "abf49a87f86d67c4b728f20bda20a58b7cf4f5a585c18887fec38fb9fe38fc74"},
+		{Expr{Query: &QueryExpr{Name: "songs", Query: labels.Query{
+			Where: labels.Predicate{Op: labels.OpEq, Key: "genre", Value: "jazz"},
+			Limit: 10,
+		}}}, "6bf1002dde5c9bd1e65e85526b70b611a3390216edba9cbc40d38678d37d482e"},
+		{Expr{Eval: &Expr{Slice: &SliceExpr{Base: a, Length: 4}}}, "7a8b2d99fc2ddb55f689cea0aedf4db9df1e28371f7649cc0daefbf95da3af5f"},
+	}
+	for i, tc := range tcs {
+		id := hcorpus.Hash(Marshal(tc.Expr))
+		require.Equal(t, tc.ID, id.String(), "test case %d", i)
+	}
+}
+
+func TestMarshalParse(t *testing.T) {
+	a := hcorpus.Hash([]byte("a"))
+	ref, root := testRefs(a, hcorpus.Hash([]byte("b")))
+	xs := []Expr{
+		NewGLFS(glfs.Ref{}),
+		NewGLFS(ref),
+		NewGotFS(gotfs.Root{}, ""),
+		NewGotFS(root, "dir"),
+		NewSlice(a, 0, 1<<40),
+		NewArchiveMember(a, ArchiveTar, "x"),
+		NewDecompress(a, CodecBzip2),
+		NewList(nil),
+		NewSet([]hcorpus.ID{a}),
+		{Query: &QueryExpr{Index: "id3v2", Query: labels.Query{Where: labels.Predicate{Op: labels.OpExists, Key: "title"}}}},
+		{Query: &QueryExpr{Query: labels.Query{Where: labels.Predicate{Op: labels.OpOR, SubQueries: []labels.Query{
+			{Where: labels.Predicate{Op: labels.OpIn, Key: "genre", Values: []string{"jazz", "blues"}}, Limit: 5},
+			{Where: labels.Predicate{Op: labels.OpPrefix, Key: "title", Value: "so", CaseInsensitive: true}},
+		}}}}},
+		{Eval: &Expr{Eval: &Expr{List: &ListExpr{a}}}},
+	}
+	for i, x := range xs {
+		data := Marshal(x)
+		v, err := VersionOf(data)
+		require.NoError(t, err)
+		require.Equal(t, CurrentVersion, v)
+		y, err := ParseExpr(data)
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, data, Marshal(*y), "test case %d", i)
+		if x.Query == nil {
+			// queries are parsed in their canonical form.
+			require.Equal(t, x, *y, "test case %d", i)
+		}
+
+		_, err = ParseExpr(data[:len(data)-1])
+		require.Error(t, err, "test case %d", i)
+		_, err = ParseExpr(append(data, 0))
+		require.Error(t, err, "test case %d", i)
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	a := hcorpus.Hash([]byte("a"))
+	data := []byte(`{"slice":{"base":[` + joinBytes(a[:]) + `],"offset":1,"length":2}}`)
+	v, err := VersionOf(data)
+	require.NoError(t, err)
+	require.Equal(t, 0, v)
+	x, err := ParseExpr(data)
+	require.NoError(t, err)
+	require.Equal(t, NewSlice(a, 1, 2), *x)
+}
+
+// testRefs returns a glfs.Ref and a gotfs.Root with every field set.
+func testRefs(a, b hcorpus.ID) (glfs.Ref, gotfs.Root) {
+	ref := glfs.Ref{Type: glfs.TypeBlob}
+	ref.CID = cadata.ID(a)
+	copy(ref.DEK[:], b[:])
+	root := gotfs.Root{Depth: 2, First: []byte("first")}
+	root.Ref.CID = cadata.ID(b)
+	copy(root.Ref.DEK[:], a[:])
+	return ref, root
+}
+
+func joinBytes(x []byte) (ret string) {
+	for i, b := range x {
+		if i > 0 {
+			ret += ","
+		}
+		ret += fmt.Sprint(b)
+	}
+	return ret
+}
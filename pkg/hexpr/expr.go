@@ -1,6 +1,7 @@
 package hexpr
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,7 +9,12 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/blobcache/glfs"
 	"github.com/gotvc/got/pkg/gotfs"
@@ -25,16 +31,71 @@ func NewGLFS(ref glfs.Ref) Expr {
 	}
 }
 
+// GotFSExpr refers to a file or directory in a gotfs filesystem.
+// Files evaluate to their contents, and directories to a listing of their entries.
 type GotFSExpr struct {
 	Root gotfs.Root `json:"root"`
 	Path string     `json:"path"`
 }
 
+func NewGotFS(root gotfs.Root, p string) Expr {
+	return Expr{
+		GotFS: &GotFSExpr{Root: root, Path: p},
+	}
+}
+
+// DirEntry is an entry in the listing of a directory.
+// ID is the ID of the GotFSExpr for the entry.
+type DirEntry struct {
+	Name string      `json:"name"`
+	Mode os.FileMode `json:"mode"`
+	ID   hcorpus.ID  `json:"id"`
+}
+
+// SliceExpr refers to Length bytes of the value of Base, starting at Offset.
+type SliceExpr struct {
+	Base   hcorpus.ID `json:"base"`
+	Offset int64      `json:"offset"`
+	Length int64      `json:"length"`
+}
+
+func NewSlice(base hcorpus.ID, offset, length int64) Expr {
+	return Expr{
+		Slice: &SliceExpr{Base: base, Offset: offset, Length: length},
+	}
+}
+
 type ListExpr = []hcorpus.ID
 
+func NewList(ids []hcorpus.ID) Expr {
+	l := ListExpr(append([]hcorpus.ID{}, ids...))
+	return Expr{List: &l}
+}
+
 type SetExpr = []hcorpus.ID
 
+// NewSet returns a Set expression containing ids.
+// The IDs are sorted and deduplicated, so that equal sets have equal expressions.
+func NewSet(ids []hcorpus.ID) Expr {
+	s := SetExpr(append([]hcorpus.ID{}, ids...))
+	sort.Slice(s, func(i, j int) bool {
+		return bytes.Compare(s[i][:], s[j][:]) < 0
+	})
+	var n int
+	for i := range s {
+		if i == 0 || s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	s = s[:n]
+	return Expr{Set: &s}
+}
+
+// QueryExpr evaluates to the list of IDs matching Query.
+// If Index is empty, the query is run against all the indexes.
 type QueryExpr = struct {
+	Name  string       `json:"name,omitempty"`
 	Index string       `json:"index"`
 	Query labels.Query `json:"query"`
 }
@@ -42,6 +103,10 @@ type QueryExpr = struct {
 type Expr struct {
 	GLFS  *GLFSExpr  `json:"glfs,omitempty"`
 	GotFS *GotFSExpr `json:"gotfs,omitempty"`
+	Slice *SliceExpr `json:"slice,omitempty"`
+
+	Archive    *ArchiveExpr    `json:"archive,omitempty"`
+	Decompress *DecompressExpr `json:"decompress,omitempty"`
 
 	List *ListExpr `json:"list,omitempty"`
 	Set  *SetExpr  `json:"set,omitempty"`
@@ -50,42 +115,229 @@ type Expr struct {
 	Eval  *Expr      `json:"eval,omitempty"`
 }
 
-func Marshal(e Expr) []byte {
-	data, err := json.Marshal(e)
-	if err != nil {
-		panic(err)
+type Kind string
+
+const (
+	KindGLFS       = Kind("glfs")
+	KindGotFS      = Kind("gotfs")
+	KindSlice      = Kind("slice")
+	KindArchive    = Kind("archive")
+	KindDecompress = Kind("decompress")
+	KindList       = Kind("list")
+	KindSet        = Kind("set")
+	KindQuery      = Kind("query")
+	KindEval       = Kind("eval")
+)
+
+// Kind returns the Kind of the variant set in e, or "" if e is empty.
+func (e Expr) Kind() Kind {
+	switch {
+	case e.GLFS != nil:
+		return KindGLFS
+	case e.GotFS != nil:
+		return KindGotFS
+	case e.Slice != nil:
+		return KindSlice
+	case e.Archive != nil:
+		return KindArchive
+	case e.Decompress != nil:
+		return KindDecompress
+	case e.List != nil:
+		return KindList
+	case e.Set != nil:
+		return KindSet
+	case e.Query != nil:
+		return KindQuery
+	case e.Eval != nil:
+		return KindEval
+	default:
+		return ""
 	}
-	return data
 }
 
-func ParseExpr(x []byte) (*Expr, error) {
-	var e Expr
-	if err := json.Unmarshal(x, &e); err != nil {
-		return nil, err
+// MapIDs returns a copy of e, with every ID it refers to replaced by fn(ID).
+func (e Expr) MapIDs(fn func(hcorpus.ID) hcorpus.ID) Expr {
+	mapIDs := func(ids []hcorpus.ID) []hcorpus.ID {
+		ret := make([]hcorpus.ID, len(ids))
+		for i := range ids {
+			ret[i] = fn(ids[i])
+		}
+		return ret
+	}
+	switch {
+	case e.Slice != nil:
+		return NewSlice(fn(e.Slice.Base), e.Slice.Offset, e.Slice.Length)
+	case e.Archive != nil:
+		x := *e.Archive
+		x.Base = fn(x.Base)
+		return Expr{Archive: &x}
+	case e.Decompress != nil:
+		return NewDecompress(fn(e.Decompress.Base), e.Decompress.Codec)
+	case e.List != nil:
+		return NewList(mapIDs(*e.List))
+	case e.Set != nil:
+		return NewSet(mapIDs(*e.Set))
+	case e.Eval != nil:
+		x := e.Eval.MapIDs(fn)
+		return Expr{Eval: &x}
+	default:
+		return e
 	}
-	return &e, nil
 }
 
+// IsMutable returns true if the value of e can change, for instance as the indexes change.
+// Expressions referred to by ID are not considered, since they are not available here.
 func (e Expr) IsMutable() bool {
-	switch {
-	case e.GLFS != nil:
-		return false
-	case e.GotFS != nil:
-		return false
-	case e.Query != nil:
+	switch e.Kind() {
+	case KindQuery:
 		return true
+	case KindEval:
+		return e.Eval.IsMutable()
 	default:
-		panic(e)
+		return false
+	}
+}
+
+// Validate returns an error if e does not have exactly one variant set, or if the variant is malformed.
+func (e Expr) Validate() error {
+	var kinds []Kind
+	for _, x := range []struct {
+		kind Kind
+		set  bool
+	}{
+		{KindGLFS, e.GLFS != nil},
+		{KindGotFS, e.GotFS != nil},
+		{KindSlice, e.Slice != nil},
+		{KindArchive, e.Archive != nil},
+		{KindDecompress, e.Decompress != nil},
+		{KindList, e.List != nil},
+		{KindSet, e.Set != nil},
+		{KindQuery, e.Query != nil},
+		{KindEval, e.Eval != nil},
+	} {
+		if x.set {
+			kinds = append(kinds, x.kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return errors.New("empty expression")
+	}
+	if len(kinds) > 1 {
+		return fmt.Errorf("expression has more than one variant set: %v", kinds)
+	}
+	switch kinds[0] {
+	case KindGotFS:
+		if p := e.GotFS.Path; p != "" && (path.Clean(p) != p || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../")) {
+			return fmt.Errorf("gotfs: invalid path %q", p)
+		}
+	case KindSlice:
+		if e.Slice.Offset < 0 || e.Slice.Length < 0 {
+			return fmt.Errorf("slice: negative offset or length [%d:+%d]", e.Slice.Offset, e.Slice.Length)
+		}
+	case KindArchive:
+		if e.Archive.Format != ArchiveZip && e.Archive.Format != ArchiveTar {
+			return fmt.Errorf("archive: unknown format %q", e.Archive.Format)
+		}
+		if e.Archive.Path == "" {
+			return errors.New("archive: empty path")
+		}
+		if e.Archive.Index < 0 {
+			return fmt.Errorf("archive: negative index %d", e.Archive.Index)
+		}
+	case KindDecompress:
+		switch e.Decompress.Codec {
+		case CodecGzip, CodecBzip2, CodecZlib:
+		default:
+			return fmt.Errorf("decompress: unknown codec %q", e.Decompress.Codec)
+		}
+	case KindSet:
+		s := *e.Set
+		for i := 1; i < len(s); i++ {
+			if bytes.Compare(s[i-1][:], s[i][:]) >= 0 {
+				return errors.New("set: IDs are not sorted and unique")
+			}
+		}
+	case KindEval:
+		if err := e.Eval.Validate(); err != nil {
+			return fmt.Errorf("eval: %w", err)
+		}
 	}
+	return nil
 }
 
 type Value struct {
+	// Type is the type of structured values such as "List[ID]" or "Dir", and empty for bytes.
 	Type string
 	Data io.ReaderAt
+
+	// MIMEType and Size are set by Sniff, or from a cache of its results.
+	// Size is SizeUnknown if it could not be found without reading all of Data, see LoadSize.
+	MIMEType string
+	Size     int64
 }
 
+// SizeUnknown is the Size of a Value whose size is only known after reading it, like decompressed data.
+const SizeUnknown = -1
+
 func (v *Value) NewReader() io.ReadSeeker {
-	return io.NewSectionReader(v.Data, 0, math.MaxInt64)
+	size := int64(math.MaxInt64)
+	if v.MIMEType != "" && v.Size != SizeUnknown {
+		size = v.Size
+	} else if s, ok := v.Data.(interface{ Size() int64 }); ok {
+		size = s.Size()
+	}
+	return io.NewSectionReader(v.Data, 0, size)
+}
+
+// Sniff sets MIMEType and Size from the contents of v.
+// Only the start of the contents is read, so Size is SizeUnknown if Data does not know its size.
+func (v *Value) Sniff() error {
+	size := int64(SizeUnknown)
+	if s, ok := v.Data.(interface{ Size() int64 }); ok {
+		size = s.Size()
+	}
+	buf := make([]byte, 512)
+	n, err := v.Data.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	v.MIMEType = detectType(v.Type, buf[:n])
+	v.Size = size
+	return nil
+}
+
+// LoadSize returns the size of v, reading all of its contents if the size is unknown.
+func (v *Value) LoadSize() (int64, error) {
+	if v.MIMEType != "" && v.Size != SizeUnknown {
+		return v.Size, nil
+	}
+	size, err := sizeOf(v.Data)
+	if err != nil {
+		return 0, err
+	}
+	v.Size = size
+	return size, nil
+}
+
+// detectType returns the MIME type of a value of type ty beginning with data.
+// It recognizes the formats handled by the evaluator and indexers, in addition to those known by http.DetectContentType.
+func detectType(ty string, data []byte) string {
+	switch {
+	case ty == "Dir":
+		return "application/json"
+	case ty != "":
+		return "application/octet-stream"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case len(data) >= 262 && bytes.Equal(data[257:262], []byte("ustar")):
+		return "application/x-tar"
+	case bytes.HasPrefix(data, []byte("BZh")):
+		return "application/x-bzip2"
+	}
+	if codec, ok := DetectCompression(bytes.NewReader(data)); ok && codec == CodecZlib {
+		return "application/zlib"
+	}
+	return http.DetectContentType(data)
 }
 
 func (v *Value) ForEach(fn func(ID hcorpus.ID) error) error {
@@ -126,27 +378,81 @@ func (v *Value) ForEach(fn func(ID hcorpus.ID) error) error {
 	}
 }
 
+// DefaultMaxDepth is the default for Evaluator.MaxDepth
+const DefaultMaxDepth = 64
+
 type Evaluator struct {
 	GetExpr         func(context.Context, hcorpus.ID) (*Expr, error)
-	GetQueryBackend func(string) labels.QueryBackend
+	GetQueryBackend func(string) (labels.QueryBackend, error)
 
 	OpenGLFS  func(glfs.Ref) (io.ReaderAt, error)
 	OpenGotFS func(gotfs.Root, string) (io.ReaderAt, error)
+	// ReadDirGotFS returns the entries in the directory at the path, and true.
+	// If the path is not a directory it returns false.
+	ReadDirGotFS func(gotfs.Root, string) ([]gotfs.DirEnt, bool, error)
+
+	// MaxDepth limits how deeply expressions can refer to other expressions.
+	// If it is 0, DefaultMaxDepth is used.
+	MaxDepth int
+
+	mu   sync.Mutex
+	memo map[hcorpus.ID]Value
 }
 
+// Eval evaluates x.
+// The values of x and the expressions it refers to are remembered by ID, and reused by later calls.
+// Expressions are only evaluated once for the lifetime of the Evaluator, so it should not outlive the state it reads.
 func (ev *Evaluator) Eval(ctx context.Context, x Expr) (*Value, error) {
-	return ev.eval(ctx, hcorpus.Hash(Marshal(*x.Eval)), x)
+	return ev.eval(ctx, nil, hcorpus.Hash(Marshal(x)), x)
 }
 
+// EvalID evaluates the expression identified by id.
 func (ev *Evaluator) EvalID(ctx context.Context, id hcorpus.ID) (*Value, error) {
+	return ev.evalID(ctx, nil, id)
+}
+
+// evalID evaluates the expression identified by id.
+// stack holds the IDs of the expressions being evaluated which refer to it.
+func (ev *Evaluator) evalID(ctx context.Context, stack []hcorpus.ID, id hcorpus.ID) (*Value, error) {
+	if v, ok := ev.getMemo(id); ok {
+		return v, nil
+	}
 	e, err := ev.GetExpr(ctx, id)
 	if err != nil {
-		return nil, err
+		return nil, &EvalError{ID: id, Err: err}
+	}
+	return ev.eval(ctx, stack, id, *e)
+}
+
+func (ev *Evaluator) eval(ctx context.Context, stack []hcorpus.ID, id hcorpus.ID, x Expr) (*Value, error) {
+	if v, ok := ev.getMemo(id); ok {
+		return v, nil
+	}
+	maxDepth := ev.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if len(stack) >= maxDepth {
+		return nil, &EvalError{ID: id, Kind: x.Kind(), Err: fmt.Errorf("exceeded max depth of %d", maxDepth)}
 	}
-	return ev.eval(ctx, id, *e)
+	for _, id2 := range stack {
+		if id2 == id {
+			return nil, &EvalError{ID: id, Kind: x.Kind(), Err: errors.New("expression refers to itself")}
+		}
+	}
+	if err := x.Validate(); err != nil {
+		return nil, &EvalError{ID: id, Kind: x.Kind(), Err: err}
+	}
+	stack = append(stack[:len(stack):len(stack)], id)
+	v, err := ev.evalExpr(ctx, stack, x)
+	if err != nil {
+		return nil, &EvalError{ID: id, Kind: x.Kind(), Err: err}
+	}
+	ev.putMemo(id, *v)
+	return v, nil
 }
 
-func (ev *Evaluator) eval(ctx context.Context, id hcorpus.ID, x Expr) (*Value, error) {
+func (ev *Evaluator) evalExpr(ctx context.Context, stack []hcorpus.ID, x Expr) (*Value, error) {
 	switch {
 	case x.GLFS != nil:
 		rc, err := ev.OpenGLFS(*x.GLFS)
@@ -155,22 +461,60 @@ func (ev *Evaluator) eval(ctx context.Context, id hcorpus.ID, x Expr) (*Value, e
 		}
 		return &Value{Data: rc}, nil
 	case x.GotFS != nil:
-		// TODO: handle directories
+		ents, isDir, err := ev.ReadDirGotFS(x.GotFS.Root, x.GotFS.Path)
+		if err != nil {
+			return nil, err
+		}
+		if isDir {
+			return newDirValue(x.GotFS.Root, x.GotFS.Path, ents)
+		}
 		r, err := ev.OpenGotFS(x.GotFS.Root, x.GotFS.Path)
 		if err != nil {
 			return nil, err
 		}
 		return &Value{Data: r}, nil
+	case x.Slice != nil:
+		v, err := ev.evalID(ctx, stack, x.Slice.Base)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Data: io.NewSectionReader(v.Data, x.Slice.Offset, x.Slice.Length)}, nil
+	case x.Archive != nil:
+		v, err := ev.evalID(ctx, stack, x.Archive.Base)
+		if err != nil {
+			return nil, err
+		}
+		r, err := openArchiveMember(x.Archive.Format, v.Data, x.Archive.Path, x.Archive.Index)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Data: r}, nil
+	case x.Decompress != nil:
+		v, err := ev.evalID(ctx, stack, x.Decompress.Base)
+		if err != nil {
+			return nil, err
+		}
+		r, err := newDecompressor(x.Decompress.Codec, v.Data)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Data: r}, nil
+	case x.List != nil:
+		return newIDsValue("List[ID]", *x.List), nil
+	case x.Set != nil:
+		return newIDsValue("Set[ID]", *x.Set), nil
 	case x.Query != nil:
-		qb := ev.GetQueryBackend(x.Query.Index)
+		qb, err := ev.GetQueryBackend(x.Query.Index)
+		if err != nil {
+			return nil, err
+		}
 		resultSet, err := labels.DoQuery(ctx, qb, x.Query.Query)
 		if err != nil {
 			return nil, err
 		}
-		rs := io.NewSectionReader(&idStream{ids: resultSet.IDs}, 0, int64(len(resultSet.IDs)*32))
-		return &Value{Type: "List[ID]", Data: rs}, nil
+		return newIDsValue("List[ID]", resultSet.IDs), nil
 	case x.Eval != nil:
-		v, err := ev.Eval(ctx, *x.Eval)
+		v, err := ev.eval(ctx, stack, hcorpus.Hash(Marshal(*x.Eval)), *x.Eval)
 		if err != nil {
 			return nil, err
 		}
@@ -182,20 +526,81 @@ func (ev *Evaluator) eval(ctx context.Context, id hcorpus.ID, x Expr) (*Value, e
 		if err != nil {
 			return nil, err
 		}
-		return ev.eval(ctx, hcorpus.Hash(data), *x2)
+		return ev.eval(ctx, stack, hcorpus.Hash(data), *x2)
 	default:
 		return nil, errors.New("empty expression")
 	}
 }
 
+func (ev *Evaluator) getMemo(id hcorpus.ID) (*Value, bool) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	v, ok := ev.memo[id]
+	return &v, ok
+}
+
+func (ev *Evaluator) putMemo(id hcorpus.ID, v Value) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if ev.memo == nil {
+		ev.memo = make(map[hcorpus.ID]Value)
+	}
+	ev.memo[id] = v
+}
+
+// EvalError is returned when evaluating an expression fails.
+// Err may be another EvalError, for the sub-expression which failed.
+type EvalError struct {
+	ID   hcorpus.ID
+	Kind Kind
+	Err  error
+}
+
+func (e *EvalError) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("evaluating %v: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("evaluating %v (%s): %v", e.ID, e.Kind, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// newDirValue returns a Value containing a JSON array of DirEntries
+func newDirValue(root gotfs.Root, p string, ents []gotfs.DirEnt) (*Value, error) {
+	dirEnts := make([]DirEntry, len(ents))
+	for i, ent := range ents {
+		dirEnts[i] = DirEntry{
+			Name: ent.Name,
+			Mode: ent.Mode,
+			ID:   hcorpus.Hash(Marshal(NewGotFS(root, path.Join(p, ent.Name)))),
+		}
+	}
+	data, err := json.Marshal(dirEnts)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{Type: "Dir", Data: bytes.NewReader(data)}, nil
+}
+
+// newIDsValue returns a Value containing the concatenation of ids
+func newIDsValue(ty string, ids []hcorpus.ID) *Value {
+	rs := io.NewSectionReader(&idStream{ids: ids}, 0, int64(len(ids)*32))
+	return &Value{Type: ty, Data: rs}
+}
+
 type idStream struct {
 	ids []hcorpus.ID
 }
 
 func (s *idStream) ReadAt(p []byte, offset int64) (n int, err error) {
-	for i := int(offset) / 32; i < len(s.ids) && n < len(p); i++ {
-		n2 := copy(p[n:], s.ids[i][:])
-		n += n2
+	for i := int(offset / 32); i < len(s.ids) && n < len(p); i++ {
+		start := 0
+		if i == int(offset/32) {
+			start = int(offset % 32)
+		}
+		n += copy(p[n:], s.ids[i][start:])
 	}
 	if n < len(p) {
 		err = io.EOF
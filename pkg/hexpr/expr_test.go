@@ -0,0 +1,343 @@
+package hexpr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/blobcache/glfs"
+	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+)
+
+func TestSlice(t *testing.T) {
+	ctx := context.Background()
+	base := NewGLFS(glfs.Ref{})
+	baseID := hcorpus.Hash(Marshal(base))
+	ev := &Evaluator{
+		GetExpr: func(ctx context.Context, id hcorpus.ID) (*Expr, error) {
+			require.Equal(t, baseID, id)
+			return &base, nil
+		},
+		OpenGLFS: func(glfs.Ref) (io.ReaderAt, error) {
+			return bytes.NewReader([]byte("0123456789")), nil
+		},
+	}
+	tcs := []struct {
+		Offset, Length int64
+		Expected       string
+	}{
+		{0, 10, "0123456789"},
+		{3, 4, "3456"},
+		{8, 5, "89"},
+		{12, 1, ""},
+	}
+	for i, tc := range tcs {
+		v, err := ev.Eval(ctx, NewSlice(baseID, tc.Offset, tc.Length))
+		require.NoError(t, err, "test case %d", i)
+		data, err := ioutil.ReadAll(v.NewReader())
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, tc.Expected, string(data), "test case %d", i)
+	}
+	_, err := ev.Eval(ctx, NewSlice(baseID, -1, 1))
+	require.Error(t, err)
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	// the archives contain a second file at a.txt, which must not hide the first.
+	files := []struct {
+		Name, Contents string
+	}{
+		{"a.txt", "contents of a"},
+		{"dir/b.txt", "contents of b"},
+		{"a.txt", "contents of the second a"},
+	}
+	var zipBuf, tarBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	tw := tar.NewWriter(&tarBuf)
+	for _, f := range files {
+		method := zip.Deflate
+		if f.Name == "a.txt" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: method})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(f.Contents))
+		require.NoError(t, err)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.Name, Mode: 0o644, Size: int64(len(f.Contents))}))
+		_, err = tw.Write([]byte(f.Contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, tw.Close())
+
+	for format, data := range map[ArchiveFormat][]byte{
+		ArchiveZip: zipBuf.Bytes(),
+		ArchiveTar: tarBuf.Bytes(),
+	} {
+		base := NewGLFS(glfs.Ref{})
+		baseID := hcorpus.Hash(Marshal(base))
+		ev := &Evaluator{
+			GetExpr: func(ctx context.Context, id hcorpus.ID) (*Expr, error) {
+				return &base, nil
+			},
+			OpenGLFS: func(glfs.Ref) (io.ReaderAt, error) {
+				return bytes.NewReader(data), nil
+			},
+		}
+		format2, ok := DetectArchive(bytes.NewReader(data))
+		require.True(t, ok)
+		require.Equal(t, format, format2)
+		paths, err := ListArchive(format, bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a.txt", "dir/b.txt", "a.txt"}, paths)
+		for i, f := range files {
+			e := NewArchiveMember(baseID, format, f.Name)
+			if i == 2 {
+				e.Archive.Index = 1
+			}
+			v, err := ev.Eval(ctx, e)
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(v.NewReader())
+			require.NoError(t, err)
+			require.Equal(t, f.Contents, string(actual))
+		}
+		_, err = ev.Eval(ctx, NewArchiveMember(baseID, format, "c.txt"))
+		require.Error(t, err)
+		e := NewArchiveMember(baseID, format, "a.txt")
+		e.Archive.Index = 2
+		_, err = ev.Eval(ctx, e)
+		require.Error(t, err)
+	}
+}
+
+func TestDetectArchiveV7(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0o644, Size: 1}))
+	_, err := tw.Write([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	data := buf.Bytes()
+
+	// clear the ustar magic and version, and recompute the checksum, which leaves a V7 header.
+	hdr := data[:512]
+	for i := 257; i < 265; i++ {
+		hdr[i] = 0
+	}
+	for i := 148; i < 156; i++ {
+		hdr[i] = ' '
+	}
+	var sum int
+	for _, b := range hdr {
+		sum += int(b)
+	}
+	copy(hdr[148:], fmt.Sprintf("%06o\x00", sum))
+
+	format, ok := DetectArchive(bytes.NewReader(data))
+	require.True(t, ok)
+	require.Equal(t, ArchiveTar, format)
+	paths, err := ListArchive(format, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt"}, paths)
+
+	_, ok = DetectArchive(bytes.NewReader(bytes.Repeat([]byte("x"), 1024)))
+	require.False(t, ok)
+}
+
+func TestDecompress(t *testing.T) {
+	ctx := context.Background()
+	expected := bytes.Repeat([]byte("hello world "), 1000)
+	var gzBuf, zlibBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	_, err := gw.Write(expected)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	zw := zlib.NewWriter(&zlibBuf)
+	_, err = zw.Write(expected)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	for codec, data := range map[Codec][]byte{
+		CodecGzip: gzBuf.Bytes(),
+		CodecZlib: zlibBuf.Bytes(),
+	} {
+		codec2, ok := DetectCompression(bytes.NewReader(data))
+		require.True(t, ok)
+		require.Equal(t, codec, codec2)
+
+		base := NewGLFS(glfs.Ref{})
+		ev := &Evaluator{
+			GetExpr: func(ctx context.Context, id hcorpus.ID) (*Expr, error) {
+				return &base, nil
+			},
+			OpenGLFS: func(glfs.Ref) (io.ReaderAt, error) {
+				return bytes.NewReader(data), nil
+			},
+		}
+		v, err := ev.Eval(ctx, NewDecompress(hcorpus.Hash(Marshal(base)), codec))
+		require.NoError(t, err)
+		// read the end first, so the stream has to be reopened.
+		buf := make([]byte, 12)
+		n, err := v.Data.ReadAt(buf, int64(len(expected)-12))
+		require.NoError(t, err)
+		require.Equal(t, expected[len(expected)-12:], buf[:n])
+		actual, err := ioutil.ReadAll(v.NewReader())
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+	_, ok := DetectCompression(bytes.NewReader(expected))
+	require.False(t, ok)
+}
+
+func TestSniff(t *testing.T) {
+	tcs := []struct {
+		Value    Value
+		MIMEType string
+		Size     int64
+	}{
+		{Value{Data: bytes.NewReader([]byte("fLaC\x00\x00\x00\x22"))}, "audio/flac", 8},
+		{Value{Data: bytes.NewReader([]byte("hello world\n"))}, "text/plain; charset=utf-8", 12},
+		{Value{Data: bytes.NewReader([]byte("x^abc"))}, "text/plain; charset=utf-8", 5},
+		{*newIDsValue("List[ID]", make([]hcorpus.ID, 3)), "application/octet-stream", 96},
+	}
+	for i, tc := range tcs {
+		v := tc.Value
+		require.NoError(t, v.Sniff(), "test case %d", i)
+		require.Equal(t, tc.MIMEType, v.MIMEType, "test case %d", i)
+		require.Equal(t, tc.Size, v.Size, "test case %d", i)
+	}
+
+	// the size of decompressed data is only found by reading it.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("hello world\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	data, err := newDecompressor(CodecGzip, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	v := Value{Data: data}
+	require.NoError(t, v.Sniff())
+	require.Equal(t, "text/plain; charset=utf-8", v.MIMEType)
+	require.Equal(t, int64(SizeUnknown), v.Size)
+	actual, err := io.ReadAll(v.NewReader())
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", string(actual))
+	size, err := v.LoadSize()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), size)
+	require.Equal(t, int64(12), v.Size)
+}
+
+func TestEvalSafety(t *testing.T) {
+	ctx := context.Background()
+	exprs := map[hcorpus.ID]Expr{}
+	var opens int
+	ev := &Evaluator{
+		GetExpr: func(ctx context.Context, id hcorpus.ID) (*Expr, error) {
+			e, ok := exprs[id]
+			if !ok {
+				return nil, errors.New("not found")
+			}
+			return &e, nil
+		},
+		OpenGLFS: func(glfs.Ref) (io.ReaderAt, error) {
+			opens++
+			return bytes.NewReader([]byte("0123456789")), nil
+		},
+		MaxDepth: 10,
+	}
+	id := func(i int) hcorpus.ID {
+		return hcorpus.Hash([]byte{byte(i)})
+	}
+
+	// a chain of slices, within the depth limit
+	exprs[id(0)] = NewGLFS(glfs.Ref{})
+	for i := 1; i < 10; i++ {
+		exprs[id(i)] = NewSlice(id(i-1), 1, 100)
+	}
+	v, err := ev.EvalID(ctx, id(9))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(v.NewReader())
+	require.NoError(t, err)
+	require.Equal(t, "9", string(data))
+	// the base is memoized
+	_, err = ev.EvalID(ctx, id(5))
+	require.NoError(t, err)
+	require.Equal(t, 1, opens)
+
+	// too deep, with nothing memoized
+	exprs[id(10)] = NewSlice(id(9), 0, 1)
+	ev = &Evaluator{GetExpr: ev.GetExpr, OpenGLFS: ev.OpenGLFS, MaxDepth: 10}
+	_, err = ev.EvalID(ctx, id(10))
+	require.ErrorContains(t, err, "max depth")
+
+	// a cycle
+	exprs[id(20)] = NewSlice(id(21), 0, 1)
+	exprs[id(21)] = NewDecompress(id(20), CodecGzip)
+	_, err = ev.EvalID(ctx, id(20))
+	require.ErrorContains(t, err, "refers to itself")
+
+	// errors identify the sub-expression which failed
+	exprs[id(30)] = NewSlice(id(31), 0, 1)
+	_, err = ev.EvalID(ctx, id(30))
+	var evalErr *EvalError
+	require.ErrorAs(t, err, &evalErr)
+	require.Equal(t, id(30), evalErr.ID)
+	require.ErrorAs(t, evalErr.Err, &evalErr)
+	require.Equal(t, id(31), evalErr.ID)
+}
+
+func TestValidate(t *testing.T) {
+	a := hcorpus.Hash([]byte("a"))
+	b := hcorpus.Hash([]byte("b"))
+	tcs := []struct {
+		Expr  Expr
+		Valid bool
+	}{
+		{Expr{}, false},
+		{NewSlice(a, 0, 1), true},
+		{NewSlice(a, -1, 1), false},
+		{Expr{Slice: &SliceExpr{Base: a}, List: &ListExpr{a}}, false},
+		{NewArchiveMember(a, ArchiveTar, "x"), true},
+		{NewArchiveMember(a, "rar", "x"), false},
+		{NewArchiveMember(a, ArchiveZip, ""), false},
+		{NewDecompress(a, "lzma"), false},
+		{NewSet([]hcorpus.ID{b, a, b}), true},
+		{Expr{Set: &SetExpr{a, a}}, false},
+		{NewGotFS(gotfs.Root{}, ""), true},
+		{NewGotFS(gotfs.Root{}, "a/b"), true},
+		{NewGotFS(gotfs.Root{}, "../a"), false},
+		{NewGotFS(gotfs.Root{}, ".."), false},
+		{NewGotFS(gotfs.Root{}, "/a"), false},
+		{Expr{Eval: &Expr{}}, false},
+		{Expr{Eval: &Expr{List: &ListExpr{}}}, true},
+	}
+	for i, tc := range tcs {
+		err := tc.Expr.Validate()
+		if tc.Valid {
+			require.NoError(t, err, "test case %d", i)
+		} else {
+			require.Error(t, err, "test case %d", i)
+			// the legacy encoding can represent any Expr
+			data, err := json.Marshal(tc.Expr)
+			require.NoError(t, err)
+			_, err = ParseExpr(data)
+			require.Error(t, err, "test case %d", i)
+		}
+	}
+	require.True(t, Expr{Eval: &Expr{Query: &QueryExpr{}}}.IsMutable())
+	require.False(t, Expr{Eval: &Expr{List: &ListExpr{}}}.IsMutable())
+}
@@ -0,0 +1,110 @@
+package hindex
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotkv"
+	"github.com/pkg/errors"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+// CacheKey identifies the results of a query against a set of index roots.
+type CacheKey [32]byte
+
+// CacheRoot is the root of a query result cache.
+// Index roots are immutable, so cached results never need to be invalidated, only discarded.
+// The cache is structured like this:
+/*
+<cache_key> -> <count>
+<cache_key><index> -> <id>
+<cache_key><index> -> <id>
+...
+*/
+type CacheRoot = gotkv.Root
+
+// MakeRootsKey returns a key identifying the set of index roots
+func MakeRootsKey(roots map[string]Root) CacheKey {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var data []byte
+	for _, name := range names {
+		root := roots[name]
+		data = appendLP(data, []byte(name))
+		data = append(data, root.Ref.CID[:]...)
+		data = append(data, root.Ref.DEK[:]...)
+		data = append(data, root.Depth)
+		data = appendLP(data, root.First)
+	}
+	return CacheKey(hcorpus.Hash(data))
+}
+
+// MakeCacheKey returns the key for the results of q against roots
+func MakeCacheKey(roots map[string]Root, q labels.Query) CacheKey {
+	rootsKey := MakeRootsKey(roots)
+	data := append([]byte{}, rootsKey[:]...)
+	data = appendLP(data, labels.MarshalQuery(q))
+	return CacheKey(hcorpus.Hash(data))
+}
+
+// GetCached returns the cached results for key, and true, or false if they are not in the cache.
+func (o *Operator) GetCached(ctx context.Context, s cadata.Store, root CacheRoot, key CacheKey) ([]OID, bool, error) {
+	countBytes, err := o.gotkv.Get(ctx, s, root, key[:])
+	if err != nil {
+		if errors.Is(err, gotkv.ErrKeyNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(countBytes) != 8 {
+		return nil, false, errors.Errorf("invalid cache entry count %q", countBytes)
+	}
+	count := binary.BigEndian.Uint64(countBytes)
+	ids := make([]OID, 0, count)
+	if err := o.gotkv.ForEach(ctx, s, root, gotkv.PrefixSpan(key[:]), func(ent gotkv.Entry) error {
+		if len(ent.Key) == len(key) {
+			return nil
+		}
+		ids = append(ids, hcorpus.IDFromBytes(ent.Value))
+		return nil
+	}); err != nil {
+		return nil, false, err
+	}
+	if uint64(len(ids)) != count {
+		return nil, false, errors.Errorf("cache entry has %d results, expected %d", len(ids), count)
+	}
+	return ids, true, nil
+}
+
+// PutCached adds the results for key to the cache
+func (o *Operator) PutCached(ctx context.Context, s cadata.Store, root CacheRoot, key CacheKey, ids []OID) (*CacheRoot, error) {
+	ents := make([]gotkv.Entry, 0, len(ids)+1)
+	var countBytes [8]byte
+	binary.BigEndian.PutUint64(countBytes[:], uint64(len(ids)))
+	ents = append(ents, gotkv.Entry{Key: append([]byte{}, key[:]...), Value: countBytes[:]})
+	for i, id := range ids {
+		k := make([]byte, len(key)+8)
+		copy(k, key[:])
+		binary.BigEndian.PutUint64(k[len(key):], uint64(i))
+		ents = append(ents, gotkv.Entry{Key: k, Value: append([]byte{}, id[:]...)})
+	}
+	return o.gotkv.Mutate(ctx, s, root, gotkv.Mutation{
+		Span:    gotkv.PrefixSpan(key[:]),
+		Entries: ents,
+	})
+}
+
+// appendLP appends x to out, prefixed by its length
+func appendLP(out, x []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(x)))
+	out = append(out, lenBuf[:n]...)
+	return append(out, x...)
+}
@@ -0,0 +1,51 @@
+package hindex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	roots := map[string]Root{"a": *root}
+	q1 := labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: "k", Value: "1"}}
+	q2 := labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: "k", Value: "2"}}
+	k1, k2 := MakeCacheKey(roots, q1), MakeCacheKey(roots, q2)
+	require.NotEqual(t, k1, k2)
+
+	croot, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	_, ok, err := op.GetCached(ctx, s, *croot, k1)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ids := []OID{hcorpus.Hash([]byte("a")), hcorpus.Hash([]byte("b"))}
+	croot, err = op.PutCached(ctx, s, *croot, k1, ids)
+	require.NoError(t, err)
+	croot, err = op.PutCached(ctx, s, *croot, k2, nil)
+	require.NoError(t, err)
+
+	actual, ok, err := op.GetCached(ctx, s, *croot, k1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ids, actual)
+	actual, ok, err = op.GetCached(ctx, s, *croot, k2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, actual, 0)
+
+	// a different root is a different key
+	root2, err := op.AddTags(ctx, s, *root, ids[0], []labels.Pair{{Key: "k", Value: []byte("1")}})
+	require.NoError(t, err)
+	require.NotEqual(t, k1, MakeCacheKey(map[string]Root{"a": *root2}, q1))
+	require.NotEqual(t, MakeRootsKey(roots), MakeRootsKey(map[string]Root{"a": *root2}))
+	require.NotEqual(t, MakeRootsKey(roots), MakeRootsKey(map[string]Root{"b": *root}))
+}
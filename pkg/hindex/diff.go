@@ -0,0 +1,27 @@
+package hindex
+
+import (
+	"context"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotkv"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+)
+
+// Diff calls fn with each label which differs between left and right, ordered by ID then key.
+// left and right are the values of the label in each index, or nil if the object does not have the label.
+// A nil Root is an empty index.
+// Parts of the index shared by left and right are skipped without being read, see hcorpus.DiffTrees.
+func (o *Operator) Diff(ctx context.Context, s cadata.Store, left, right *Root, fn func(id OID, key string, left, right []byte) error) error {
+	return hcorpus.DiffTrees(ctx, &o.gotkv, s, (*gotkv.Root)(left), (*gotkv.Root)(right), func(k, lv, rv []byte) error {
+		if len(k) == 0 || k[0] != 'f' {
+			return nil
+		}
+		key, id, err := parseForwardKey(k)
+		if err != nil {
+			return err
+		}
+		return fn(id, string(key), lv, rv)
+	})
+}
@@ -85,6 +85,32 @@ func (o *Operator) AddTags(ctx context.Context, s cadata.Store, root Root, fp OI
 	return o.gotkv.Mutate(ctx, s, root, muts...)
 }
 
+// PutTag sets the value of tag.Key for fp to tag.Value, replacing any existing value.
+func (o *Operator) PutTag(ctx context.Context, s cadata.Store, root Root, fp OID, tag labels.Pair) (*Root, error) {
+	root2, err := o.DeleteTag(ctx, s, root, fp, tag.Key)
+	if err != nil {
+		return nil, err
+	}
+	return o.AddTags(ctx, s, *root2, fp, []labels.Pair{tag})
+}
+
+// DeleteTag removes the value of tagKey for fp, if there is one.
+func (o *Operator) DeleteTag(ctx context.Context, s cadata.Store, root Root, fp OID, tagKey string) (*Root, error) {
+	value, err := o.GetTagValue(ctx, s, root, fp, tagKey)
+	if errors.Is(err, gotkv.ErrKeyNotFound) {
+		return &root, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tag := labels.Pair{Key: tagKey, Value: value}
+	forwardKey := makeForwardKey(nil, fp, []byte(tagKey))
+	inverseKey := makeInverseKey(nil, tag, fp)
+	return o.gotkv.Mutate(ctx, s, root,
+		gotkv.Mutation{Span: gotkv.SingleKeySpan(forwardKey)},
+		gotkv.Mutation{Span: gotkv.SingleKeySpan(inverseKey)},
+	)
+}
+
 func (o *Operator) GetTags(ctx context.Context, s cadata.Store, root Root, oid OID) (ret []labels.Pair, _ error) {
 	span := gotkv.PrefixSpan(makeForwardKey(nil, oid, nil))
 	if err := o.gotkv.ForEach(ctx, s, root, span, func(ent gotkv.Entry) error {
@@ -112,7 +138,7 @@ func (o *Operator) ForEach(ctx context.Context, s cadata.Store, root Root, fn fu
 	span := prefixSpan(gotkv.TotalSpan(), []byte{'f', 0x00})
 	var currentFP OID
 	var tags []labels.Pair
-	return o.gotkv.ForEach(ctx, s, root, span, func(ent gotkv.Entry) error {
+	if err := o.gotkv.ForEach(ctx, s, root, span, func(ent gotkv.Entry) error {
 		fp, key, value, err := parseForwardEntry(ent)
 		if err != nil {
 			return err
@@ -131,7 +157,13 @@ func (o *Operator) ForEach(ctx context.Context, s cadata.Store, root Root, fn fu
 			Value: append([]byte{}, value...),
 		})
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		return fn(currentFP, tags)
+	}
+	return nil
 }
 
 func (o *Operator) ForEachKey(ctx context.Context, s cadata.Store, root Root, fn func(string) error) error {
@@ -153,10 +185,7 @@ func (o *Operator) ForEachKey(ctx context.Context, s cadata.Store, root Root, fn
 }
 
 func (o *Operator) ForEachValue(ctx context.Context, s cadata.Store, root Root, tagKey string, fn func([]byte) error) error {
-	prefix := []byte{'i', 0x00}
-	prefix = append(prefix, tagKey...)
-	prefix = append(prefix, 0x00)
-	span := gotkv.PrefixSpan(prefix)
+	span := gotkv.PrefixSpan(makeInverseKeyPrefix(nil, tagKey))
 	return o.gotkv.ForEach(ctx, s, root, span, func(ent gotkv.Entry) error {
 		_, _, value, err := parseInverseEntry(ent)
 		if err != nil {
@@ -221,11 +250,17 @@ func parseForwardEntry(ent gotkv.Entry) (OID, []byte, []byte, error) {
 	return fp, key, ent.Value, nil
 }
 
-func makeInverseKey(out []byte, tag labels.Pair, fp OID) []byte {
+// makeInverseKeyPrefix appends the prefix shared by all the inverse entries for tagKey
+func makeInverseKeyPrefix(out []byte, tagKey string) []byte {
 	out = append(out, 'i')
 	out = append(out, 0x00)
-	out = append(out, []byte(tag.Key)...)
+	out = append(out, tagKey...)
 	out = append(out, 0x00)
+	return out
+}
+
+func makeInverseKey(out []byte, tag labels.Pair, fp OID) []byte {
+	out = makeInverseKeyPrefix(out, tag.Key)
 	out = append(out, []byte(tag.Value)...)
 	out = append(out, 0x00)
 	out = append(out, fp[:]...)
@@ -0,0 +1,230 @@
+package hindex
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/gotvc/got/pkg/gotkv"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	const N = 10
+	for i := 0; i < N; i++ {
+		id := hcorpus.Hash([]byte(fmt.Sprint(i)))
+		tags := []labels.Pair{
+			{Key: "n", Value: []byte(fmt.Sprint(i))},
+			{Key: "parity", Value: []byte([]string{"even", "odd"}[i%2])},
+			{Key: "parity_name", Value: []byte("parity")},
+			{Key: "title", Value: []byte(fmt.Sprintf([]string{"Song %d", "song %d"}[i%2], i))},
+		}
+		if i == 0 || i == 1 || i == 4 || i == 9 {
+			tags = append(tags, labels.Pair{Key: "square", Value: []byte("true")})
+		}
+		root, err = op.AddTags(ctx, s, *root, id, tags)
+		require.NoError(t, err)
+	}
+	eq := func(k, v string) labels.Query {
+		return labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: k, Value: v}}
+	}
+	tcs := []struct {
+		Query labels.Query
+		Count int
+	}{
+		{labels.Query{}, N},
+		{labels.Query{Limit: 3}, 3},
+		{eq("parity", "even"), N / 2},
+		{eq("parity", "parity"), 0},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpLt, Key: "n", Value: "3"}}, 3},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpGt, Key: "n", Value: "3"}}, 6},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpPrefix, Key: "title", Value: "Song"}}, 5},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpPrefix, Key: "title", Value: "song", CaseInsensitive: true}}, N},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: "title", Value: "SONG 3", CaseInsensitive: true}}, 1},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpContains, Key: "title", Value: "NG 1", CaseInsensitive: true}}, 1},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpRegexp, Key: "title", Value: "^Song [0-4]"}}, 3},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpRegexp, Key: "title", Value: "ong [0-4]$"}}, 5},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpExists, Key: "square"}}, 4},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpMissing, Key: "square"}}, 6},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpMissing, Key: "n"}}, 0},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpAND, SubQueries: []labels.Query{
+			eq("parity", "odd"),
+			{Where: labels.Predicate{Op: labels.OpMissing, Key: "square"}},
+		}}}, 3},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpOR, SubQueries: []labels.Query{
+			eq("parity", "even"),
+			eq("n", "2"),
+			eq("n", "3"),
+		}}}, N/2 + 1},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpOR, SubQueries: []labels.Query{
+			eq("parity", "even"),
+			eq("parity", "odd"),
+		}}, Limit: 7}, 7},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpAND, SubQueries: []labels.Query{
+			eq("parity", "even"),
+			{Where: labels.Predicate{Op: labels.OpLt, Key: "n", Value: "5"}},
+		}}}, 3},
+	}
+	for i, tc := range tcs {
+		res, err := op.Search(ctx, s, *root, tc.Query)
+		require.NoError(t, err, "test case %d", i)
+		require.Len(t, res.IDs, tc.Count, "test case %d", i)
+		seen := map[labels.ID]struct{}{}
+		for _, id := range res.IDs {
+			require.NotContains(t, seen, id, "test case %d", i)
+			seen[id] = struct{}{}
+		}
+	}
+
+	// a limited sub-query contributes the same IDs whichever branch it is in.
+	var total int
+	for _, parity := range []string{"even", "odd"} {
+		res, err := op.Search(ctx, s, *root, labels.Query{Where: labels.Predicate{Op: labels.OpAND, SubQueries: []labels.Query{
+			eq("parity", parity),
+			{Where: labels.Predicate{Op: labels.OpAny}, Limit: 4},
+		}}})
+		require.NoError(t, err)
+		total += len(res.IDs)
+	}
+	require.Equal(t, 4, total)
+}
+
+func TestFederated(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	roots := map[string]*Root{}
+	for _, name := range []string{"a", "b"} {
+		var err error
+		roots[name], err = op.NewEmpty(ctx, s)
+		require.NoError(t, err)
+	}
+	addTags := func(name string, i int, tags ...labels.Pair) {
+		var err error
+		roots[name], err = op.AddTags(ctx, s, *roots[name], hcorpus.Hash([]byte(fmt.Sprint(i))), tags)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 4; i++ {
+		addTags("a", i, labels.Pair{Key: "artist", Value: []byte("x")})
+		addTags("b", i, labels.Pair{Key: "sample_rate", Value: []byte("44100")})
+	}
+	// both indexes have a title for 0 and 1, and they disagree about 1
+	addTags("a", 0, labels.Pair{Key: "title", Value: []byte("zero")})
+	addTags("b", 0, labels.Pair{Key: "title", Value: []byte("zero")})
+	addTags("a", 1, labels.Pair{Key: "title", Value: []byte("one")})
+	addTags("b", 1, labels.Pair{Key: "title", Value: []byte("uno")})
+	addTags("b", 2, labels.Pair{Key: "title", Value: []byte("two")})
+
+	be := labels.NewFederated(map[string]labels.QueryBackend{
+		"a": op.NewQueryBackend(s, *roots["a"]),
+		"b": op.NewQueryBackend(s, *roots["b"]),
+	})
+	eq := func(k, v string) labels.Query {
+		return labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: k, Value: v}}
+	}
+	tcs := []struct {
+		Query labels.Query
+		Count int
+	}{
+		{labels.Query{}, 4},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpExists, Key: "title"}}, 3},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpMissing, Key: "title"}}, 1},
+		{eq("title", "one"), 1},
+		{eq("title", "uno"), 0},
+		{eq("b.title", "uno"), 1},
+		{eq("a.title", "two"), 0},
+		{labels.Query{Where: labels.Predicate{Op: labels.OpAND, SubQueries: []labels.Query{
+			eq("artist", "x"),
+			eq("sample_rate", "44100"),
+		}}}, 4},
+	}
+	for i, tc := range tcs {
+		res, err := labels.DoQuery(ctx, be, tc.Query)
+		require.NoError(t, err, "test case %d", i)
+		require.Len(t, res.IDs, tc.Count, "test case %d", i)
+	}
+}
+
+func setup(t testing.TB) (*Operator, cadata.Store) {
+	op := New()
+	s := cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
+	return op, s
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	a, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	id := func(i int) OID {
+		return hcorpus.Hash([]byte(fmt.Sprint(i)))
+	}
+	a, err = op.AddTags(ctx, s, *a, id(0), []labels.Pair{{Key: "title", Value: []byte("zero")}})
+	require.NoError(t, err)
+	a, err = op.AddTags(ctx, s, *a, id(1), []labels.Pair{{Key: "title", Value: []byte("one")}})
+	require.NoError(t, err)
+	b, err := op.AddTags(ctx, s, *a, id(1), []labels.Pair{{Key: "title", Value: []byte("uno")}, {Key: "artist", Value: []byte("x")}})
+	require.NoError(t, err)
+
+	type change struct {
+		ID          OID
+		Key         string
+		Left, Right string
+	}
+	diff := func(left, right *Root) (ret []change) {
+		require.NoError(t, op.Diff(ctx, s, left, right, func(id OID, key string, l, r []byte) error {
+			ret = append(ret, change{id, key, string(l), string(r)})
+			return nil
+		}))
+		return ret
+	}
+	require.Len(t, diff(a, a), 0)
+	require.ElementsMatch(t, []change{
+		{id(1), "artist", "", "x"},
+		{id(1), "title", "one", "uno"},
+	}, diff(a, b))
+	require.ElementsMatch(t, []change{
+		{id(0), "title", "zero", ""},
+		{id(1), "title", "one", ""},
+	}, diff(a, nil))
+}
+
+func TestPutDeleteTag(t *testing.T) {
+	ctx := context.Background()
+	op, s := setup(t)
+	root, err := op.NewEmpty(ctx, s)
+	require.NoError(t, err)
+	id := hcorpus.Hash([]byte("0"))
+	count := func(value string) int {
+		rs, err := op.Search(ctx, s, *root, labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: "title", Value: value}})
+		require.NoError(t, err)
+		return len(rs.IDs)
+	}
+	root, err = op.PutTag(ctx, s, *root, id, labels.Pair{Key: "title", Value: []byte("old")})
+	require.NoError(t, err)
+	require.Equal(t, 1, count("old"))
+	root, err = op.PutTag(ctx, s, *root, id, labels.Pair{Key: "title", Value: []byte("new")})
+	require.NoError(t, err)
+	require.Equal(t, 0, count("old"))
+	require.Equal(t, 1, count("new"))
+	value, err := op.GetTagValue(ctx, s, *root, id, "title")
+	require.NoError(t, err)
+	require.Equal(t, "new", string(value))
+
+	root, err = op.DeleteTag(ctx, s, *root, id, "title")
+	require.NoError(t, err)
+	require.Equal(t, 0, count("new"))
+	_, err = op.GetTagValue(ctx, s, *root, id, "title")
+	require.ErrorIs(t, err, gotkv.ErrKeyNotFound)
+	// deleting a missing tag is not an error
+	_, err = op.DeleteTag(ctx, s, *root, id, "title")
+	require.NoError(t, err)
+}
@@ -2,10 +2,12 @@ package hindex
 
 import (
 	"context"
+	"errors"
 
 	"github.com/brendoncarroll/go-state/cadata"
 	"github.com/brendoncarroll/hoard/pkg/labels"
 	"github.com/gotvc/got/pkg/gotkv"
+	"github.com/gotvc/got/pkg/gotkv/kvstreams"
 )
 
 var _ labels.QueryBackend = QueryBackend{}
@@ -16,41 +18,67 @@ type QueryBackend struct {
 	root Root
 }
 
-func (qb QueryBackend) ScanForward(ctx context.Context, span labels.Span, fn labels.IterFunc) error {
+func (qb QueryBackend) IterateForward(ctx context.Context, span labels.Span) labels.EntryIterator {
 	span2 := prefixSpan(gotkv.Span{Begin: span.Begin, End: span.End}, []byte{'f', 0x00})
-	return qb.op.gotkv.ForEach(ctx, qb.s, qb.root, span2, func(ent gotkv.Entry) error {
-		fp, key, value, err := parseForwardEntry(ent)
-		if err != nil {
-			return err
-		}
-		return fn(fp, key, value)
-	})
+	return &entryIterator{
+		it: qb.op.gotkv.NewIterator(qb.s, qb.root, span2),
+		parse: func(ent gotkv.Entry, dst *labels.Entry) error {
+			fp, key, value, err := parseForwardEntry(ent)
+			if err != nil {
+				return err
+			}
+			*dst = labels.Entry{ID: fp, Key: key, Value: value}
+			return nil
+		},
+	}
 }
 
 func (qb QueryBackend) GetValue(ctx context.Context, id OID, key string) ([]byte, error) {
-	return qb.op.gotkv.Get(ctx, qb.s, qb.root, makeForwardKey(nil, id, []byte(key)))
+	value, err := qb.op.gotkv.Get(ctx, qb.s, qb.root, makeForwardKey(nil, id, []byte(key)))
+	if errors.Is(err, gotkv.ErrKeyNotFound) {
+		err = labels.ErrNotFound
+	}
+	return value, err
 }
 
-func (qb QueryBackend) ScanInverted(ctx context.Context, tagKey string, fn labels.IterFunc) error {
-	var span gotkv.Span
-	if tagKey != "" {
-		span = prefixSpan(gotkv.PrefixSpan([]byte(tagKey)), []byte{'i', 0x00})
+func (qb QueryBackend) IterateInverted(ctx context.Context, tagKey string, span labels.Span) labels.EntryIterator {
+	prefix := makeInverseKeyPrefix(nil, tagKey)
+	span2 := prefixSpan(gotkv.Span{Begin: span.Begin, End: span.End}, prefix)
+	return &entryIterator{
+		it: qb.op.gotkv.NewIterator(qb.s, qb.root, span2),
+		parse: func(ent gotkv.Entry, dst *labels.Entry) error {
+			fp, key, value, err := parseInverseEntry(ent)
+			if err != nil {
+				return err
+			}
+			*dst = labels.Entry{ID: *fp, Key: key, Value: value}
+			return nil
+		},
 	}
-	return qb.op.gotkv.ForEach(ctx, qb.s, qb.root, span, func(ent gotkv.Entry) error {
-		fp, key, value, err := parseInverseEntry(ent)
-		if err != nil {
-			return err
+}
+
+type entryIterator struct {
+	it    *gotkv.Iterator
+	ent   gotkv.Entry
+	parse func(gotkv.Entry, *labels.Entry) error
+}
+
+func (it *entryIterator) Next(ctx context.Context, dst *labels.Entry) error {
+	if err := it.it.Next(ctx, &it.ent); err != nil {
+		if errors.Is(err, kvstreams.EOS) {
+			return labels.EOS
 		}
-		return fn(*fp, key, value)
-	})
+		return err
+	}
+	return it.parse(it.ent, dst)
 }
 
 func prefixSpan(x gotkv.Span, prefix []byte) gotkv.Span {
-	begin := prefix
+	begin := append([]byte{}, prefix...)
 	begin = append(begin, x.Begin...)
 	end := gotkv.PrefixEnd(prefix)
 	if x.End != nil {
-		end = prefix
+		end = append([]byte{}, prefix...)
 		end = append(end, x.End...)
 	}
 	return gotkv.Span{
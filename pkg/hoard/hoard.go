@@ -1,18 +1,28 @@
 package hoard
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
 
 	"github.com/blobcache/glfs"
 	"github.com/brendoncarroll/go-state"
 	"github.com/brendoncarroll/go-state/cadata"
 	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/posixfs"
+	"github.com/gotvc/got/pkg/gotfs"
 	"github.com/gotvc/got/pkg/gotkv"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
@@ -40,6 +50,23 @@ type Volume struct {
 	Index  cadata.Store
 
 	GLFS cadata.Store
+
+	// QueryCache, if set, holds the root of a cache of search results, which is stored in Index.
+	// It is kept separate from Cell, so that searching never writes to Cell.
+	QueryCache cells.Cell
+}
+
+// NewMemVolume returns a Volume which is stored in memory.
+func NewMemVolume() Volume {
+	s := cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
+	return Volume{
+		Cell:   cells.NewMem(1 << 16),
+		Corpus: s,
+		Index:  s,
+		GLFS:   s,
+
+		QueryCache: cells.NewMem(1 << 16),
+	}
 }
 
 type Indexer func(ctx context.Context, e hexpr.Expr, cv hexpr.Value) ([]labels.Pair, error)
@@ -47,55 +74,245 @@ type Indexer func(ctx context.Context, e hexpr.Expr, cv hexpr.Value) ([]labels.P
 type Params struct {
 	Volume   Volume
 	Indexers map[string]Indexer
+	// LabelPrecedence lists the indexes to prefer labels from, most preferred first, for each label key.
+	// Indexes which are not listed for a key are less preferred than those which are, and are ordered by name.
+	LabelPrecedence map[string][]string
 }
 
 type Hoard struct {
-	vol      Volume
-	indexers map[string]Indexer
+	vol        Volume
+	indexers   map[string]Indexer
+	precedence map[string][]string
 
 	hindex  *hindex.Operator
 	hcorpus *hcorpus.Operator
+	gotfs   gotfs.Operator
 }
 
 func New(params Params) *Hoard {
 	return &Hoard{
-		vol:     params.Volume,
-		hindex:  hindex.New(),
-		hcorpus: hcorpus.New(),
+		vol:        params.Volume,
+		indexers:   params.Indexers,
+		precedence: params.LabelPrecedence,
+		hindex:     hindex.New(),
+		hcorpus:    hcorpus.New(hcorpus.WithValidator(hexpr.ValidateData)),
+		gotfs:      gotfs.NewOperator(),
 	}
 }
 
+// Add adds the data in r as an object, and indexes it.
+// If the data is compressed, the decompressed data is also added and indexed as a separate object.
+// Add returns the ID of the object for the data as it was read from r.
 func (h *Hoard) Add(ctx context.Context, r io.Reader) (*ID, error) {
-	var ret *ID
-	ref, err := glfs.PostBlob(ctx, h.vol.GLFS, r)
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(512)
+	codec, compressed := hexpr.DetectCompression(bytes.NewReader(magic))
+	ref, err := glfs.PostBlob(ctx, h.vol.GLFS, br)
 	if err != nil {
 		return nil, err
 	}
-	e := hexpr.NewGLFS(*ref)
-	rc2, err := glfs.GetBlob(ctx, h.vol.GLFS, *ref)
+	ids, err := h.addExprs(ctx, []Expr{hexpr.NewGLFS(*ref)}, nil)
 	if err != nil {
 		return nil, err
 	}
-	v := hexpr.Value{Data: rc2}
-	if err := h.update(ctx, func(s *State) (*State, error) {
-		var croot *hcorpus.Root
-		// init
-		if s != nil {
-			croot = &s.Corpus
-		} else {
-			var err error
-			if croot, err = h.hcorpus.NewEmpty(ctx, h.vol.Corpus); err != nil {
-				return nil, err
+	if compressed {
+		// data can look compressed without being compressed, so the decompressed view is best-effort.
+		if _, err := h.addExprs(ctx, []Expr{hexpr.NewDecompress(ids[0], codec)}, nil); err != nil {
+			logrus.Warnf("could not add decompressed view of %v: %v", ids[0], err)
+		}
+	}
+	return &ids[0], nil
+}
+
+// AddTree imports the directory at p in fsx as a single gotfs filesystem.
+// Every file and directory in the tree is added to the corpus, and the files are indexed individually.
+// AddTree returns the IDs of the added expressions by their path in the tree; the root has the path "".
+func (h *Hoard) AddTree(ctx context.Context, fsx posixfs.FS, p string) (map[string]ID, error) {
+	root, err := h.gotfs.NewEmpty(ctx, h.vol.GLFS)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Clean(p)
+	dirs := []string{""}
+	var files []string
+	addFile := func(p2, rel string) error {
+		f, err := fsx.OpenFile(p2, posixfs.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if root, err = h.gotfs.CreateFile(ctx, h.vol.GLFS, h.vol.GLFS, *root, rel, f); err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	}
+	// the tree is walked directly, rather than with posixfs.WalkLeaves, so that empty directories are kept.
+	var walk func(p2, rel string) error
+	walk = func(p2, rel string) error {
+		ents, err := posixfs.ReadDir(fsx, p2)
+		if err != nil {
+			return err
+		}
+		for _, ent := range ents {
+			p3, rel2 := path.Join(p2, ent.Name), path.Join(rel, ent.Name)
+			if !ent.Mode.IsDir() {
+				if err := addFile(p3, rel2); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if root, err = h.gotfs.MkdirAll(ctx, h.vol.GLFS, *root, rel2); err != nil {
+				return err
+			}
+			dirs = append(dirs, rel2)
+			if err := walk(p3, rel2); err != nil {
+				return err
 			}
 		}
-		// add to corpus
-		fp, croot2, err := h.hcorpus.Post(ctx, h.vol.Corpus, *croot, hexpr.Marshal(e))
+		return nil
+	}
+	finfo, err := fsx.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+	if finfo.IsDir() {
+		err = walk(base, "")
+	} else {
+		err = addFile(base, path.Base(base))
+	}
+	if err != nil {
+		return nil, err
+	}
+	dirs = maps.Keys(makeSet(dirs))
+	slices.Sort(dirs)
+
+	var toIndex, toPost []Expr
+	for _, p := range files {
+		toIndex = append(toIndex, hexpr.NewGotFS(*root, p))
+	}
+	for _, p := range dirs {
+		toPost = append(toPost, hexpr.NewGotFS(*root, p))
+	}
+	ids, err := h.addExprs(ctx, toIndex, toPost)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]ID, len(ids))
+	for i, p := range append(files, dirs...) {
+		ret[p] = ids[i]
+	}
+	return ret, nil
+}
+
+// AddSlice adds the bytes [offset, offset+length) of the object base as an object, and indexes it.
+// The bytes are not copied, the slice is read from base when it is evaluated.
+func (h *Hoard) AddSlice(ctx context.Context, base ID, offset, length int64) (*ID, error) {
+	x, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.getExpr(ctx, x, base); err != nil {
+		return nil, errors.Wrapf(err, "slice base %v", base)
+	}
+	ids, err := h.addExprs(ctx, []Expr{hexpr.NewSlice(base, offset, length)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ids[0], nil
+}
+
+// ExpandArchive adds each file in the archive identified by id as an object, and indexes it.
+// It returns the IDs of the added objects by their path in the archive.
+// If more than one file has the same path, the later files are returned as "<path>#<n>", where n counts from 1.
+// If the object is not a zip or tar archive, ExpandArchive returns an empty map.
+func (h *Hoard) ExpandArchive(ctx context.Context, id ID) (map[string]ID, error) {
+	v, err := h.Eval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	format, ok := hexpr.DetectArchive(v.Data)
+	if !ok {
+		return map[string]ID{}, nil
+	}
+	paths, err := hexpr.ListArchive(format, v.Data)
+	if err != nil {
+		return nil, err
+	}
+	toIndex := make([]Expr, len(paths))
+	keys := make([]string, len(paths))
+	counts := make(map[string]int)
+	for i, p := range paths {
+		e := hexpr.NewArchiveMember(id, format, p)
+		e.Archive.Index = counts[p]
+		toIndex[i] = e
+		keys[i] = p
+		if counts[p] > 0 {
+			keys[i] = fmt.Sprintf("%s#%d", p, counts[p])
+		}
+		counts[p]++
+	}
+	ids, err := h.addExprs(ctx, toIndex, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]ID, len(ids))
+	for i, k := range keys {
+		ret[k] = ids[i]
+	}
+	return ret, nil
+}
+
+// addExprs adds the expressions in toIndex and toPost to the corpus in a single update,
+// and indexes the values of the expressions in toIndex.
+// It returns the IDs of toIndex followed by the IDs of toPost.
+func (h *Hoard) addExprs(ctx context.Context, toIndex, toPost []Expr) ([]ID, error) {
+	// the values are evaluated and sniffed once, outside of the update, which can be retried.
+	x, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ev := h.newEvaluator(ctx, x)
+	vals := make([]hexpr.Value, len(toIndex))
+	stats := make([][]byte, len(toIndex))
+	for i, e := range toIndex {
+		v, err := ev.Eval(ctx, e)
 		if err != nil {
 			return nil, err
 		}
-		ret = &fp
+		if err := v.Sniff(); err != nil {
+			return nil, err
+		}
+		if stats[i], err = json.Marshal(Stat{MIMEType: v.MIMEType, Size: v.Size}); err != nil {
+			return nil, err
+		}
+		vals[i] = *v
+	}
+	var ids []ID
+	if err := h.update(ctx, func(s *State) (*State, error) {
+		ids = ids[:0]
+		// add to corpus
+		croot := &s.Corpus
+		for _, e := range append(append([]Expr{}, toIndex...), toPost...) {
+			id, croot2, err := h.hcorpus.Post(ctx, h.vol.Corpus, *croot, hexpr.Marshal(e))
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+			croot = croot2
+		}
+		if len(toIndex) == 0 {
+			s.Corpus = *croot
+			return s, nil
+		}
 		// add to indexes
-		iroots := make(map[string]hindex.Root)
+		iroots := maps.Clone(s.Indexes)
+		if iroots == nil {
+			iroots = make(map[string]hindex.Root)
+		}
 		for iname := range h.indexers {
 			if root, exists := s.Indexes[iname]; exists {
 				iroots[iname] = root
@@ -107,49 +324,129 @@ func (h *Hoard) Add(ctx context.Context, r io.Reader) (*ID, error) {
 				iroots[iname] = *r
 			}
 		}
-		eg := errgroup.Group{}
-		for iname, idxer := range h.indexers {
-			iname := iname
-			idxer := idxer
-			eg.Go(func() error {
-				tags, err := idxer(ctx, e, v)
-				if err != nil {
-					return err
-				}
-				root, err := h.hindex.AddTags(ctx, h.vol.Index, iroots[iname], fp, tags)
-				if err != nil {
-					return err
-				}
-				iroots[iname] = *root
-				return nil
-			})
-		}
-		if err := eg.Wait(); err != nil {
-			return nil, err
+		for i, e := range toIndex {
+			var err error
+			if croot, err = h.hcorpus.PutMeta(ctx, h.vol.Corpus, *croot, ids[i], stats[i]); err != nil {
+				return nil, err
+			}
+			if err := h.index(ctx, iroots, ids[i], e, vals[i]); err != nil {
+				return nil, err
+			}
 		}
 		return &State{
-			Corpus:  *croot2,
+			Corpus:  *croot,
 			Indexes: iroots,
 		}, nil
 	}); err != nil {
 		return nil, err
 	}
-	return ret, nil
+	return ids, nil
 }
 
-func (h *Hoard) NewReaderAt(ctx context.Context, id ID) (io.ReaderAt, error) {
+// index runs each indexer on v, and adds the labels to the indexes in iroots
+func (h *Hoard) index(ctx context.Context, iroots map[string]hindex.Root, id ID, e Expr, v hexpr.Value) error {
+	var mu sync.Mutex
+	eg := errgroup.Group{}
+	for iname, idxer := range h.indexers {
+		iname := iname
+		idxer := idxer
+		eg.Go(func() error {
+			tags, err := idxer(ctx, e, v)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			iroot := iroots[iname]
+			mu.Unlock()
+			root, err := h.hindex.AddTags(ctx, h.vol.Index, iroot, id, tags)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			iroots[iname] = *root
+			mu.Unlock()
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func makeSet[T comparable](xs []T) map[T]struct{} {
+	ret := make(map[T]struct{}, len(xs))
+	for _, x := range xs {
+		ret[x] = struct{}{}
+	}
+	return ret
+}
+
+// Eval evaluates the expression identified by id
+func (h *Hoard) Eval(ctx context.Context, id ID) (*hexpr.Value, error) {
 	x, err := h.get(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if x == nil {
-		return nil, errors.Errorf("no object with that id")
-	}
 	ev := h.newEvaluator(ctx, x)
 	v, err := ev.EvalID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	st, err := h.getStat(ctx, x, id)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		v.MIMEType, v.Size = st.MIMEType, st.Size
+	}
+	return v, nil
+}
+
+// Stat is information about the value of an object.
+type Stat struct {
+	MIMEType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+}
+
+// Stat returns the MIME type and size of the object identified by id.
+// They are detected when the object is added and indexed, otherwise they are detected from the value each time.
+// The size of decompressed data is not detected when it is added, so it is found by reading the whole value.
+func (h *Hoard) Stat(ctx context.Context, id ID) (*Stat, error) {
+	v, err := h.Eval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if v.MIMEType == "" {
+		if err := v.Sniff(); err != nil {
+			return nil, err
+		}
+	}
+	size, err := v.LoadSize()
+	if err != nil {
+		return nil, err
+	}
+	return &Stat{MIMEType: v.MIMEType, Size: size}, nil
+}
+
+// getStat returns the Stat cached in the corpus for id, or nil if there isn't one.
+func (h *Hoard) getStat(ctx context.Context, x *State, id ID) (*Stat, error) {
+	data, err := h.hcorpus.GetMeta(ctx, h.vol.Corpus, x.Corpus, id)
+	if err != nil {
+		if errors.Is(err, gotkv.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var st Stat
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (h *Hoard) NewReaderAt(ctx context.Context, id ID) (io.ReaderAt, error) {
+	v, err := h.Eval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 	return v.Data, nil
 }
 
@@ -164,28 +461,233 @@ func (h *Hoard) NewReader(ctx context.Context, id ID) (io.ReadSeeker, error) {
 	return io.NewSectionReader(r, 0, math.MaxInt64), nil
 }
 
+// CreateCollection adds a collection of ids to the corpus, and returns its ID.
+// kind must be hexpr.KindList or hexpr.KindSet.
+func (h *Hoard) CreateCollection(ctx context.Context, kind hexpr.Kind, ids []ID) (*ID, error) {
+	var e Expr
+	switch kind {
+	case hexpr.KindList:
+		e = hexpr.NewList(ids)
+	case hexpr.KindSet:
+		e = hexpr.NewSet(ids)
+	default:
+		return nil, errors.Errorf("%q is not a collection kind", kind)
+	}
+	return h.postCollection(ctx, e, ids)
+}
+
+// AppendToCollection adds ids to the collection identified by id, and returns the ID of the new collection.
+// Expressions are immutable, so the original collection is unchanged.
+func (h *Hoard) AppendToCollection(ctx context.Context, id ID, ids []ID) (*ID, error) {
+	x, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e, err := h.getExpr(ctx, x, id)
+	if err != nil {
+		return nil, err
+	}
+	var e2 Expr
+	switch e.Kind() {
+	case hexpr.KindList:
+		e2 = hexpr.NewList(append(append([]ID{}, *e.List...), ids...))
+	case hexpr.KindSet:
+		e2 = hexpr.NewSet(append(append([]ID{}, *e.Set...), ids...))
+	default:
+		return nil, errors.Errorf("%v is not a collection", id)
+	}
+	return h.postCollection(ctx, e2, ids)
+}
+
+// postCollection adds the collection e to the corpus, after checking that the members exist.
+func (h *Hoard) postCollection(ctx context.Context, e Expr, members []ID) (*ID, error) {
+	return h.post(ctx, e, members...)
+}
+
+// SaveQuery adds a query to the corpus, and returns its ID.
+// Evaluating the query returns the IDs matching it in the current indexes.
+func (h *Hoard) SaveQuery(ctx context.Context, name string, q labels.Query) (*ID, error) {
+	return h.post(ctx, Expr{Query: &hexpr.QueryExpr{Name: name, Query: q}})
+}
+
+// post adds e to the corpus without indexing it.
+// The update fails if any of deps are missing from the corpus it is applied to.
+func (h *Hoard) post(ctx context.Context, e Expr, deps ...ID) (*ID, error) {
+	var ret ID
+	if err := h.update(ctx, func(s *State) (*State, error) {
+		for _, id := range deps {
+			if _, err := h.hcorpus.Get(ctx, h.vol.Corpus, s.Corpus, id); err != nil {
+				return nil, errors.Wrapf(err, "referenced object %v", id)
+			}
+		}
+		id, croot, err := h.hcorpus.Post(ctx, h.vol.Corpus, s.Corpus, hexpr.Marshal(e))
+		if err != nil {
+			return nil, err
+		}
+		ret = id
+		s.Corpus = *croot
+		return s, nil
+	}); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Migrate re-encodes the expressions in the corpus which use an old encoding, and moves their labels to their new IDs.
+// Expressions which refer to migrated expressions, in any encoding, are rewritten to refer to the new IDs, so their IDs change too.
+// It returns the new ID for each migrated ID.
+func (h *Hoard) Migrate(ctx context.Context) (map[ID]ID, error) {
+	var mapping map[ID]ID
+	if err := h.update(ctx, func(s *State) (*State, error) {
+		mapping = make(map[ID]ID)
+		// every expression is loaded, since an expression in the current encoding can still refer to one which is migrated.
+		exprs := make(map[ID]Expr)
+		if err := h.hcorpus.ForEach(ctx, h.vol.Corpus, s.Corpus, gotkv.TotalSpan(), func(id ID) error {
+			data, err := h.hcorpus.Get(ctx, h.vol.Corpus, s.Corpus, id)
+			if err != nil {
+				return err
+			}
+			e, err := hexpr.ParseExpr(data)
+			if err != nil {
+				return errors.Wrapf(err, "parsing %v", id)
+			}
+			exprs[id] = *e
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		// expressions only refer to expressions which already exist, so there are no cycles.
+		// the hash of an expression is computed after the IDs it refers to have been migrated,
+		// and it is migrated if that changes its ID, either because of the new encoding or because of the new references.
+		done := make(map[ID]ID, len(exprs))
+		var newID func(ID) ID
+		newID = func(id ID) ID {
+			if id2, exists := done[id]; exists {
+				return id2
+			}
+			e, exists := exprs[id]
+			if !exists {
+				return id
+			}
+			id2 := hcorpus.Hash(hexpr.Marshal(e.MapIDs(newID)))
+			done[id] = id2
+			if id2 != id {
+				mapping[id] = id2
+			}
+			return id2
+		}
+		for id := range exprs {
+			newID(id)
+		}
+		if len(mapping) == 0 {
+			return nil, nil
+		}
+		croot := &s.Corpus
+		for id, id2 := range mapping {
+			e, err := h.getExpr(ctx, &State{Corpus: *croot}, id)
+			if err != nil {
+				return nil, err
+			}
+			if _, croot, err = h.hcorpus.Post(ctx, h.vol.Corpus, *croot, hexpr.Marshal(e.MapIDs(newID))); err != nil {
+				return nil, err
+			}
+			if meta, err := h.hcorpus.GetMeta(ctx, h.vol.Corpus, *croot, id); err == nil {
+				if croot, err = h.hcorpus.PutMeta(ctx, h.vol.Corpus, *croot, id2, meta); err != nil {
+					return nil, err
+				}
+			} else if !errors.Is(err, gotkv.ErrKeyNotFound) {
+				return nil, err
+			}
+			if croot, err = h.hcorpus.Delete(ctx, h.vol.Corpus, *croot, id); err != nil {
+				return nil, err
+			}
+		}
+		iroots := make(map[string]hindex.Root, len(s.Indexes))
+		for iname, iroot := range s.Indexes {
+			iroot2, err := h.hindex.NewEmpty(ctx, h.vol.Index)
+			if err != nil {
+				return nil, err
+			}
+			if err := h.hindex.ForEach(ctx, h.vol.Index, iroot, func(id ID, tags []labels.Pair) error {
+				iroot2, err = h.hindex.AddTags(ctx, h.vol.Index, *iroot2, newID(id), tags)
+				return err
+			}); err != nil {
+				return nil, err
+			}
+			iroots[iname] = *iroot2
+		}
+		return &State{
+			Corpus:  *croot,
+			Indexes: iroots,
+		}, nil
+	}); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func (h *Hoard) getExpr(ctx context.Context, x *State, id ID) (*Expr, error) {
+	data, err := h.hcorpus.Get(ctx, h.vol.Corpus, x.Corpus, id)
+	if err != nil {
+		return nil, err
+	}
+	return hexpr.ParseExpr(data)
+}
+
 func (h *Hoard) newEvaluator(ctx context.Context, x *State) *hexpr.Evaluator {
 	return &hexpr.Evaluator{
 		GetExpr: func(ctx context.Context, id ID) (*hexpr.Expr, error) {
-			data, err := h.hcorpus.Get(ctx, h.vol.Corpus, x.Corpus, id)
-			if err != nil {
-				return nil, err
+			return h.getExpr(ctx, x, id)
+		},
+		GetQueryBackend: func(index string) (labels.QueryBackend, error) {
+			if index == "" {
+				return h.newQueryBackend(x), nil
 			}
-			return hexpr.ParseExpr(data)
+			iroot, exists := x.Indexes[index]
+			if !exists {
+				return nil, errors.Errorf("index not found: %q", index)
+			}
+			return h.hindex.NewQueryBackend(h.vol.Index, iroot), nil
 		},
 		OpenGLFS: func(ref glfs.Ref) (io.ReaderAt, error) {
 			return glfs.GetBlob(ctx, h.vol.GLFS, ref)
 		},
+		OpenGotFS: func(root gotfs.Root, p string) (io.ReaderAt, error) {
+			return h.gotfs.NewReader(ctx, h.vol.GLFS, h.vol.GLFS, root, p)
+		},
+		ReadDirGotFS: func(root gotfs.Root, p string) ([]gotfs.DirEnt, bool, error) {
+			info, err := h.gotfs.Stat(ctx, h.vol.GLFS, root, p)
+			if err != nil {
+				return nil, false, err
+			}
+			if !os.FileMode(info.Mode).IsDir() {
+				return nil, false, nil
+			}
+			var ents []gotfs.DirEnt
+			if err := h.gotfs.ReadDir(ctx, h.vol.GLFS, root, p, func(ent gotfs.DirEnt) error {
+				ents = append(ents, ent)
+				return nil
+			}); err != nil {
+				return nil, false, err
+			}
+			return ents, true, nil
+		},
 	}
 }
 
+// GetLabels returns the labels for id in the index indexName.
+// If indexName is empty, it returns the preferred labels across all the indexes, see PreferredLabels.
 func (h *Hoard) GetLabels(ctx context.Context, id ID, indexName string) ([]labels.Pair, error) {
 	x, err := h.get(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if x == nil {
-		return nil, errors.Errorf("no object with that id")
+	if indexName == "" {
+		ls, err := h.GetAllLabelsAt(ctx, x, id)
+		if err != nil {
+			return nil, err
+		}
+		return PreferredLabels(ls), nil
 	}
 	iroot, exists := x.Indexes[indexName]
 	if !exists {
@@ -194,6 +696,73 @@ func (h *Hoard) GetLabels(ctx context.Context, id ID, indexName string) ([]label
 	return h.hindex.GetTags(ctx, h.vol.Index, iroot, id)
 }
 
+// Label is a label, and the name of the index it is in.
+type Label struct {
+	Index string
+	labels.Pair
+}
+
+// GetAllLabels returns the labels for id in every index.
+// The labels are sorted by key, and labels with the same key are sorted by the precedence of their index,
+// so the first label for each key is the preferred one.
+func (h *Hoard) GetAllLabels(ctx context.Context, id ID) ([]Label, error) {
+	x, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return h.GetAllLabelsAt(ctx, x, id)
+}
+
+// GetAllLabelsAt is like GetAllLabels, but reads the labels from x, which can be got once with GetState for many IDs.
+func (h *Hoard) GetAllLabelsAt(ctx context.Context, x *State, id ID) ([]Label, error) {
+	var ret []Label
+	for name, iroot := range x.Indexes {
+		pairs, err := h.hindex.GetTags(ctx, h.vol.Index, iroot, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			ret = append(ret, Label{Index: name, Pair: pair})
+		}
+	}
+	slices.SortFunc(ret, func(a, b Label) bool {
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		ra, rb := h.rankIndex(a.Key, a.Index), h.rankIndex(b.Key, b.Index)
+		if ra != rb {
+			return ra < rb
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return bytes.Compare(a.Value, b.Value) < 0
+	})
+	return ret, nil
+}
+
+// rankIndex returns the position of index in the precedence for key.
+func (h *Hoard) rankIndex(key, index string) int {
+	order := h.precedence[key]
+	if i := slices.Index(order, index); i >= 0 {
+		return i
+	}
+	return len(order)
+}
+
+// PreferredLabels returns the first label for each key, which is from the index with the highest precedence.
+// An index holds at most one value for each key, so there is one label for each key.
+// ls must be sorted like the result of GetAllLabels.
+func PreferredLabels(ls []Label) []labels.Pair {
+	var ret []labels.Pair
+	for i, l := range ls {
+		if i == 0 || ls[i-1].Key != l.Key {
+			ret = append(ret, l.Pair)
+		}
+	}
+	return ret
+}
+
 func (h *Hoard) ListIndexes(ctx context.Context) ([]string, error) {
 	x, err := h.get(ctx)
 	if err != nil {
@@ -204,20 +773,14 @@ func (h *Hoard) ListIndexes(ctx context.Context) ([]string, error) {
 	return keys, nil
 }
 
+// ForEachExpr calls fn with each expression in the corpus with an ID in span, in order.
 func (h *Hoard) ForEachExpr(ctx context.Context, span state.Span[cadata.ID], fn func(id ID, e hexpr.Expr) error) error {
 	x, err := h.get(ctx)
 	if err != nil {
 		return err
 	}
-	if x == nil {
-		return nil
-	}
-	return h.hcorpus.ForEach(ctx, h.vol.Corpus, x.Corpus, gotkv.TotalSpan(), func(id hcorpus.ID) error {
-		v, err := h.hcorpus.Get(ctx, h.vol.Corpus, x.Corpus, id)
-		if err != nil {
-			return err
-		}
-		e, err := hexpr.ParseExpr(v)
+	return h.forEachID(ctx, x, span, func(id ID) error {
+		e, err := h.getExpr(ctx, x, id)
 		if err != nil {
 			return err
 		}
@@ -225,14 +788,132 @@ func (h *Hoard) ForEachExpr(ctx context.Context, span state.Span[cadata.ID], fn
 	})
 }
 
+// ListIDs returns the IDs in the corpus in span, in order.
 func (h *Hoard) ListIDs(ctx context.Context, span state.Span[cadata.ID]) (ret []ID, _ error) {
-	err := h.ForEachExpr(ctx, span, func(id ID, e Expr) error {
+	x, err := h.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = h.forEachID(ctx, x, span, func(id ID) error {
 		ret = append(ret, id)
 		return nil
 	})
 	return ret, err
 }
 
+func (h *Hoard) forEachID(ctx context.Context, x *State, span state.Span[cadata.ID], fn func(ID) error) error {
+	kvSpan, ok := makeCorpusSpan(span)
+	if !ok {
+		return nil
+	}
+	return h.hcorpus.ForEach(ctx, h.vol.Corpus, x.Corpus, kvSpan, func(id ID) error {
+		// the bounds of the gotkv span are not exact, since the corpus has keys which are not IDs
+		if !span.Contains(cadata.ID(id), func(a, b cadata.ID) int { return a.Compare(b) }) {
+			return nil
+		}
+		return fn(id)
+	})
+}
+
+// makeCorpusSpan returns a gotkv.Span containing the keys for the IDs in span.
+// It returns false if the span cannot contain any IDs.
+func makeCorpusSpan(span state.Span[cadata.ID]) (gotkv.Span, bool) {
+	var ret gotkv.Span
+	if lower, ok := span.LowerBound(); ok {
+		ret.Begin = lower[:]
+		if !span.IncludesLower() {
+			// keys with the lower ID as a prefix are for the lower ID
+			if ret.Begin = gotkv.PrefixEnd(lower[:]); ret.Begin == nil {
+				return gotkv.Span{}, false
+			}
+		}
+	}
+	if upper, ok := span.UpperBound(); ok {
+		ret.End = upper[:]
+		if span.IncludesUpper() {
+			// nil means no upper bound
+			ret.End = gotkv.PrefixEnd(upper[:])
+		}
+	}
+	return ret, true
+}
+
+// ErrAmbiguousPrefix is returned by ResolvePrefix when more than one ID has the prefix
+type ErrAmbiguousPrefix struct {
+	Prefix string
+	// Candidates are some of the IDs with the prefix.
+	Candidates []ID
+}
+
+func (e ErrAmbiguousPrefix) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "prefix %q is ambiguous. try a longer one. candidates:", e.Prefix)
+	for _, id := range e.Candidates {
+		fmt.Fprintf(&sb, "\n  %v", id)
+	}
+	return sb.String()
+}
+
+// MinPrefixLen is the shortest prefix accepted by ResolvePrefix, in hex digits.
+const MinPrefixLen = 4
+
+// ResolvePrefix returns the only ID in the corpus which begins with the hex prefix.
+// The prefix must be at least MinPrefixLen digits long.
+// If more than one ID begins with prefix, it returns an ErrAmbiguousPrefix.
+func (h *Hoard) ResolvePrefix(ctx context.Context, prefix string) (ID, error) {
+	const maxCandidates = 8
+	if len(prefix) < MinPrefixLen {
+		return ID{}, errors.Errorf("ID prefix %q is too short, it must be at least %d digits", prefix, MinPrefixLen)
+	}
+	prefix = strings.ToLower(prefix)
+	// hex.DecodeString requires an even length, so every digit is checked here, including an odd last digit.
+	for i, c := range prefix {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ID{}, errors.Errorf("invalid ID prefix %q: %q at position %d is not a hex digit", prefix, c, i)
+		}
+	}
+	prefixBytes, err := hex.DecodeString(prefix[:len(prefix)/2*2])
+	if err != nil {
+		return ID{}, errors.Wrapf(err, "invalid ID prefix %q", prefix)
+	}
+	if len(prefixBytes) > len(ID{}) {
+		return ID{}, errors.Errorf("ID prefix %q is too long", prefix)
+	}
+	span := IDSpan{}.WithLowerIncl(cadata.IDFromBytes(prefixBytes))
+	if end := gotkv.PrefixEnd(prefixBytes); end != nil {
+		span = span.WithUpperExcl(cadata.IDFromBytes(end))
+	}
+	x, err := h.get(ctx)
+	if err != nil {
+		return ID{}, err
+	}
+	var candidates []ID
+	errStop := errors.New("stop iteration")
+	if err := h.forEachID(ctx, x, span, func(id ID) error {
+		if !strings.HasPrefix(id.HexString(), prefix) {
+			return nil
+		}
+		candidates = append(candidates, id)
+		if len(candidates) > maxCandidates {
+			return errStop
+		}
+		return nil
+	}); err != nil && err != errStop {
+		return ID{}, err
+	}
+	switch len(candidates) {
+	case 0:
+		return ID{}, errors.Errorf("no object with ID prefix %q", prefix)
+	case 1:
+		return candidates[0], nil
+	default:
+		if len(candidates) > maxCandidates {
+			candidates = candidates[:maxCandidates]
+		}
+		return ID{}, ErrAmbiguousPrefix{Prefix: prefix, Candidates: candidates}
+	}
+}
+
 func (h *Hoard) ForEachKey(ctx context.Context, index string, fn func(string) error) error {
 	x, err := h.get(ctx)
 	if err != nil {
@@ -273,53 +954,316 @@ func (h *Hoard) ForEachValue(ctx context.Context, index, tagKey string, fn func(
 	return nil
 }
 
-func (h *Hoard) Search(ctx context.Context, query labels.Query) (ret []ID, _ error) {
+// Search returns the IDs matching query across all the indexes.
+// Keys in the query can be qualified with the index name as "<index>.<key>"
+// If the Volume has a QueryCache, results are cached in the Volume until the indexes change.
+func (h *Hoard) Search(ctx context.Context, query labels.Query) ([]ID, error) {
 	x, err := h.get(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, iroot := range x.Indexes {
-		res, err := h.hindex.Search(ctx, h.vol.Index, iroot, query)
+	key := hindex.MakeCacheKey(x.Indexes, query)
+	if ids, ok, err := h.getCached(ctx, x, key); err != nil {
+		logrus.Warnf("reading query cache: %v", err)
+	} else if ok {
+		return ids, nil
+	}
+	res, err := labels.DoQuery(ctx, h.newQueryBackend(x), query)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.putCached(ctx, x, key, res.IDs); err != nil {
+		logrus.Warnf("writing query cache: %v", err)
+	}
+	return res.IDs, nil
+}
+
+// queryCacheState is stored in the Volume's QueryCache cell.
+// The cache only holds results for the indexes identified by Indexes, and is discarded when they change.
+type queryCacheState struct {
+	Indexes hindex.CacheKey  `json:"indexes"`
+	Root    hindex.CacheRoot `json:"root"`
+}
+
+// getCached returns the cached results for key, if they were cached for the indexes in x.
+func (h *Hoard) getCached(ctx context.Context, x *State, key hindex.CacheKey) ([]ID, bool, error) {
+	if h.vol.QueryCache == nil || len(x.Indexes) == 0 {
+		return nil, false, nil
+	}
+	data, err := cells.GetBytes(ctx, h.vol.QueryCache)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+	var cs queryCacheState
+	if err := json.Unmarshal(data, &cs); err != nil {
+		return nil, false, err
+	}
+	if cs.Indexes != hindex.MakeRootsKey(x.Indexes) {
+		return nil, false, nil
+	}
+	return h.hindex.GetCached(ctx, h.vol.Index, cs.Root, key)
+}
+
+// putCached caches ids as the results for key.
+// If the cache holds results for indexes other than those in x, it is discarded first.
+// Nothing is cached for a State without indexes, the results are always empty.
+func (h *Hoard) putCached(ctx context.Context, x *State, key hindex.CacheKey, ids []ID) error {
+	if h.vol.QueryCache == nil || len(x.Indexes) == 0 {
+		return nil
+	}
+	rootsKey := hindex.MakeRootsKey(x.Indexes)
+	return cells.Apply(ctx, h.vol.QueryCache, func(data []byte) ([]byte, error) {
+		var cs queryCacheState
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &cs); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) == 0 || cs.Indexes != rootsKey {
+			root, err := h.hindex.NewEmpty(ctx, h.vol.Index)
+			if err != nil {
+				return nil, err
+			}
+			cs = queryCacheState{Indexes: rootsKey, Root: *root}
+		}
+		root, err := h.hindex.PutCached(ctx, h.vol.Index, cs.Root, key, ids)
 		if err != nil {
 			return nil, err
 		}
-		ret = append(ret, res.IDs...)
+		cs.Root = *root
+		return json.Marshal(cs)
+	})
+}
+
+// newQueryBackend returns a QueryBackend for all the indexes in x
+func (h *Hoard) newQueryBackend(x *State) labels.QueryBackend {
+	backends := make(map[string]labels.QueryBackend, len(x.Indexes))
+	for name, iroot := range x.Indexes {
+		backends[name] = h.hindex.NewQueryBackend(h.vol.Index, iroot)
 	}
-	return ret, nil
+	return labels.NewFederated(backends)
+}
+
+// GetState returns the current State.
+// It can be saved, and compared with later States using Diff.
+func (h *Hoard) GetState(ctx context.Context) (*State, error) {
+	return h.get(ctx)
+}
+
+// Delta is a difference between two States
+type Delta struct {
+	ID ID
+	// Index is the name of the index for changes to labels, and empty for changes to the corpus.
+	Index string
+	Key   string
+	// Old and New are the values of the label in each State, or nil if the object does not have the label.
+	// For changes to the corpus, they are the encoded expression, or nil if it is not in the corpus.
+	Old, New []byte
+}
+
+// Diff calls fn with each difference from a to b.
+// Changes to the corpus come first, then changes to labels, by index name.
+// Only the parts of the corpus and indexes which differ between a and b are read,
+// so the cost of a Diff depends on the size of the changes, not the size of the Hoard.
+func (h *Hoard) Diff(ctx context.Context, a, b State, fn func(Delta) error) error {
+	if err := h.hcorpus.Diff(ctx, h.vol.Corpus, a.Corpus, b.Corpus, func(id ID, inA, inB bool) error {
+		d := Delta{ID: id}
+		var err error
+		if inA {
+			if d.Old, err = h.hcorpus.Get(ctx, h.vol.Corpus, a.Corpus, id); err != nil {
+				return err
+			}
+		}
+		if inB {
+			if d.New, err = h.hcorpus.Get(ctx, h.vol.Corpus, b.Corpus, id); err != nil {
+				return err
+			}
+		}
+		return fn(d)
+	}); err != nil {
+		return err
+	}
+	names := maps.Keys(a.Indexes)
+	for name := range b.Indexes {
+		if _, exists := a.Indexes[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		var aRoot, bRoot *hindex.Root
+		if root, exists := a.Indexes[name]; exists {
+			aRoot = &root
+		}
+		if root, exists := b.Indexes[name]; exists {
+			bRoot = &root
+		}
+		if err := h.hindex.Diff(ctx, h.vol.Index, aRoot, bRoot, func(id ID, key string, old, new []byte) error {
+			return fn(Delta{ID: id, Index: name, Key: key, Old: old, New: new})
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Conflict is a label which was changed differently in both States being merged.
+type Conflict struct {
+	ID    ID
+	Index string
+	Key   string
+	// Base, Ours and Theirs are the values of the label in each State, or nil if the object does not have the label.
+	Base, Ours, Theirs []byte
+}
+
+// Merge merges the changes from base to other into the current State.
+// base must be a State which both the current State and other have changed from, such as a saved snapshot.
+// The data referred to by other must be available in the Volume's stores.
+//
+// The corpus is content-addressed, so the merged corpus contains every expression in either State.
+// Labels are merged individually: if only one State changed a label, that change is kept.
+// If both States changed a label differently, the current value is kept, and the label is returned as a Conflict.
+// That includes labels in indexes produced by the Hoard's indexers; they are not re-derived,
+// so a Conflict there usually means the States were indexed by different versions of an indexer.
+func (h *Hoard) Merge(ctx context.Context, base, other State) ([]Conflict, error) {
+	var conflicts []Conflict
+	err := h.update(ctx, func(s *State) (*State, error) {
+		conflicts = conflicts[:0]
+		croot := &s.Corpus
+		if err := h.hcorpus.Diff(ctx, h.vol.Corpus, s.Corpus, other.Corpus, func(id ID, ours, theirs bool) error {
+			if ours {
+				return nil
+			}
+			data, err := h.hcorpus.Get(ctx, h.vol.Corpus, other.Corpus, id)
+			if err != nil {
+				return err
+			}
+			if _, croot, err = h.hcorpus.Post(ctx, h.vol.Corpus, *croot, data); err != nil {
+				return err
+			}
+			if meta, err := h.hcorpus.GetMeta(ctx, h.vol.Corpus, other.Corpus, id); err == nil {
+				croot, err = h.hcorpus.PutMeta(ctx, h.vol.Corpus, *croot, id, meta)
+				return err
+			} else if !errors.Is(err, gotkv.ErrKeyNotFound) {
+				return err
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		iroots := maps.Clone(s.Indexes)
+		if iroots == nil {
+			iroots = make(map[string]hindex.Root)
+		}
+		names := maps.Keys(other.Indexes)
+		slices.Sort(names)
+		for _, name := range names {
+			var baseRoot *hindex.Root
+			if root, exists := base.Indexes[name]; exists {
+				baseRoot = &root
+			}
+			theirRoot := other.Indexes[name]
+			ourRoot, exists := iroots[name]
+			if !exists {
+				r, err := h.hindex.NewEmpty(ctx, h.vol.Index)
+				if err != nil {
+					return nil, err
+				}
+				ourRoot = *r
+			}
+			root := &ourRoot
+			if err := h.hindex.Diff(ctx, h.vol.Index, baseRoot, &theirRoot, func(id ID, key string, baseValue, theirValue []byte) error {
+				ourValue, err := h.hindex.GetTagValue(ctx, h.vol.Index, ourRoot, id, key)
+				if errors.Is(err, gotkv.ErrKeyNotFound) {
+					ourValue, err = nil, nil
+				} else if ourValue == nil {
+					ourValue = []byte{}
+				}
+				if err != nil {
+					return err
+				}
+				switch {
+				case bytes.Equal(ourValue, theirValue) && (ourValue == nil) == (theirValue == nil):
+				case bytes.Equal(ourValue, baseValue) && (ourValue == nil) == (baseValue == nil):
+					// only they changed it
+					if theirValue == nil {
+						root, err = h.hindex.DeleteTag(ctx, h.vol.Index, *root, id, key)
+					} else {
+						root, err = h.hindex.PutTag(ctx, h.vol.Index, *root, id, labels.Pair{Key: key, Value: theirValue})
+					}
+					return err
+				default:
+					conflicts = append(conflicts, Conflict{
+						ID:     id,
+						Index:  name,
+						Key:    key,
+						Base:   baseValue,
+						Ours:   ourValue,
+						Theirs: theirValue,
+					})
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			iroots[name] = *root
+		}
+		return &State{
+			Corpus:  *croot,
+			Indexes: iroots,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
 }
 
+// update calls fn with the current State, and replaces it with the State fn returns, or leaves it unchanged if fn returns nil.
+// The corpus is created by the first update, so fn is always called with a State which can be added to.
 func (h *Hoard) update(ctx context.Context, fn func(*State) (*State, error)) error {
 	return cells.Apply(ctx, h.vol.Cell, func(data []byte) ([]byte, error) {
-		var x *State
+		x := &State{}
 		if len(data) > 0 {
-			x = &State{}
 			if err := json.Unmarshal(data, x); err != nil {
 				return nil, err
 			}
+		} else {
+			croot, err := h.hcorpus.NewEmpty(ctx, h.vol.Corpus)
+			if err != nil {
+				return nil, err
+			}
+			x.Corpus = *croot
 		}
 		y, err := fn(x)
 		if err != nil {
 			return nil, err
 		}
 		if y == nil {
-			return nil, nil
+			return data, nil
 		}
 		return json.Marshal(y)
 	})
 }
 
+// get returns the current State.
+// If nothing has been added to the Hoard, it returns an empty State.
 func (h *Hoard) get(ctx context.Context) (*State, error) {
+	data, err := cells.GetBytes(ctx, h.vol.Cell)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		// the corpus is created by the first update, see update.
+		return &State{}, nil
+	}
 	var x State
-	if err := getJSON(ctx, h.vol.Cell, &x); err != nil {
+	if err := json.Unmarshal(data, &x); err != nil {
 		return nil, err
 	}
 	return &x, nil
 }
-
-func getJSON(ctx context.Context, cell cells.Cell, x interface{}) error {
-	data, err := cells.GetBytes(ctx, cell)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(data, &x)
-}
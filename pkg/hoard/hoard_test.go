@@ -0,0 +1,348 @@
+package hoard_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/blobcache/glfs"
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/posixfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/hexpr"
+	"github.com/brendoncarroll/hoard/pkg/hoard"
+	"github.com/brendoncarroll/hoard/pkg/hoard/hoardtest"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+func TestMemVolume(t *testing.T) {
+	hoardtest.TestVolume(t, func(t testing.TB) hoard.Volume {
+		return hoard.NewMemVolume()
+	})
+}
+
+func TestEmptyReadOnly(t *testing.T) {
+	ctx := context.Background()
+	vol := hoard.NewMemVolume()
+	h := hoard.New(hoard.Params{Volume: vol})
+	ids, err := h.ListIDs(ctx, hoard.IDSpan{})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = h.Search(ctx, labels.Query{})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	_, err = h.Eval(ctx, hcorpus.Hash([]byte("a")))
+	require.Error(t, err)
+
+	// nothing has been written to the cell or the store.
+	data, err := cells.GetBytes(ctx, vol.Cell)
+	require.NoError(t, err)
+	require.Empty(t, data)
+	n, err := vol.Corpus.List(ctx, cadata.Span{}, make([]cadata.ID, 1))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// migrating an empty hoard leaves the cell empty.
+	mapping, err := h.Migrate(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mapping)
+	data, err = cells.GetBytes(ctx, vol.Cell)
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+func TestSearchCache(t *testing.T) {
+	ctx := context.Background()
+	vol := hoard.NewMemVolume()
+	newHoard := func() *hoard.Hoard {
+		return hoard.New(hoard.Params{
+			Volume: vol,
+			Indexers: map[string]hoard.Indexer{
+				"tags": func(context.Context, hexpr.Expr, hexpr.Value) ([]labels.Pair, error) {
+					return []labels.Pair{{Key: "k", Value: []byte("v")}}, nil
+				},
+			},
+		})
+	}
+	h := newHoard()
+	id, err := h.Add(ctx, strings.NewReader("data"))
+	require.NoError(t, err)
+	before, err := cells.GetBytes(ctx, vol.Cell)
+	require.NoError(t, err)
+	q := labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: "k", Value: "v"}}
+	ids, err := h.Search(ctx, q)
+	require.NoError(t, err)
+	require.Equal(t, []hoard.ID{*id}, ids)
+
+	// the results are cached in the Volume, and the cell is unchanged.
+	after, err := cells.GetBytes(ctx, vol.Cell)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+	cached, err := cells.GetBytes(ctx, vol.QueryCache)
+	require.NoError(t, err)
+	require.NotEmpty(t, cached)
+	ids, err = newHoard().Search(ctx, q)
+	require.NoError(t, err)
+	require.Equal(t, []hoard.ID{*id}, ids)
+	cached2, err := cells.GetBytes(ctx, vol.QueryCache)
+	require.NoError(t, err)
+	require.Equal(t, cached, cached2)
+
+	// changing the indexes discards the cache.
+	id2, err := h.Add(ctx, strings.NewReader("more data"))
+	require.NoError(t, err)
+	ids, err = h.Search(ctx, q)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hoard.ID{*id, *id2}, ids)
+}
+
+func TestAddTree(t *testing.T) {
+	ctx := context.Background()
+	fsx := posixfs.NewDirFS(t.TempDir())
+	require.NoError(t, posixfs.MkdirAll(fsx, "tree/a", 0o755))
+	require.NoError(t, posixfs.MkdirAll(fsx, "tree/empty", 0o755))
+	require.NoError(t, posixfs.PutFile(ctx, fsx, "tree/a/b.txt", 0o644, strings.NewReader("b")))
+	require.NoError(t, posixfs.PutFile(ctx, fsx, "tree/c.txt", 0o644, strings.NewReader("c")))
+	h := hoard.New(hoard.Params{
+		Volume:   hoard.NewMemVolume(),
+		Indexers: map[string]hoard.Indexer{},
+	})
+	ids, err := h.AddTree(ctx, fsx, "tree")
+	require.NoError(t, err)
+	// empty directories are kept.
+	require.ElementsMatch(t, []string{"", "a", "a/b.txt", "c.txt", "empty"}, maps.Keys(ids))
+	v, err := h.Eval(ctx, ids["empty"])
+	require.NoError(t, err)
+	require.Equal(t, "Dir", v.Type)
+
+	ids, err = h.AddTree(ctx, fsx, "tree/c.txt")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"", "c.txt"}, maps.Keys(ids))
+}
+
+func TestAddCorruptCompressed(t *testing.T) {
+	ctx := context.Background()
+	h := hoard.New(hoard.Params{
+		Volume:   hoard.NewMemVolume(),
+		Indexers: map[string]hoard.Indexer{},
+	})
+	// the data starts with the gzip magic number, but does not decompress.
+	data := "\x1f\x8bnot really gzip"
+	id, err := h.Add(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	v, err := h.Eval(ctx, *id)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(v.NewReader())
+	require.NoError(t, err)
+	require.Equal(t, data, string(actual))
+}
+
+func TestStatDecompressed(t *testing.T) {
+	ctx := context.Background()
+	h := hoard.New(hoard.Params{
+		Volume:   hoard.NewMemVolume(),
+		Indexers: map[string]hoard.Indexer{},
+	})
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("hello world\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	id, err := h.Add(ctx, &buf)
+	require.NoError(t, err)
+	// the size of the decompressed view is found when it is needed, not when it is added.
+	did := hcorpus.Hash(hexpr.Marshal(hexpr.NewDecompress(*id, hexpr.CodecGzip)))
+	v, err := h.Eval(ctx, did)
+	require.NoError(t, err)
+	require.Equal(t, int64(hexpr.SizeUnknown), v.Size)
+	st, err := h.Stat(ctx, did)
+	require.NoError(t, err)
+	require.Equal(t, &hoard.Stat{MIMEType: "text/plain; charset=utf-8", Size: 12}, st)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	vol := hoard.NewMemVolume()
+	ref, err := glfs.PostBlob(ctx, vol.GLFS, strings.NewReader("hello world"))
+	require.NoError(t, err)
+	// the member is in the original JSON encoding, and the list which refers to it is in the current encoding.
+	member, err := json.Marshal(hexpr.NewGLFS(*ref))
+	require.NoError(t, err)
+	memberID := hcorpus.Hash(member)
+	hc := hcorpus.New()
+	croot, err := hc.NewEmpty(ctx, vol.Corpus)
+	require.NoError(t, err)
+	_, croot, err = hc.Post(ctx, vol.Corpus, *croot, member)
+	require.NoError(t, err)
+	listID, croot, err := hc.Post(ctx, vol.Corpus, *croot, hexpr.Marshal(hexpr.NewList([]hoard.ID{memberID})))
+	require.NoError(t, err)
+	data, err := json.Marshal(hoard.State{Corpus: *croot})
+	require.NoError(t, err)
+	require.NoError(t, cells.Apply(ctx, vol.Cell, func([]byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	h := hoard.New(hoard.Params{Volume: vol})
+	mapping, err := h.Migrate(ctx)
+	require.NoError(t, err)
+	require.Len(t, mapping, 2)
+	exprs := make(map[hoard.ID]hexpr.Expr)
+	require.NoError(t, h.ForEachExpr(ctx, hoard.IDSpan{}, func(id hoard.ID, e hexpr.Expr) error {
+		exprs[id] = e
+		return nil
+	}))
+	require.Len(t, exprs, 2)
+	require.Equal(t, hexpr.NewGLFS(*ref), exprs[mapping[memberID]])
+	require.Equal(t, hexpr.NewList([]hoard.ID{mapping[memberID]}), exprs[mapping[listID]])
+
+	mapping, err = h.Migrate(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mapping)
+}
+
+func TestResolvePrefix(t *testing.T) {
+	ctx := context.Background()
+	h := hoard.New(hoard.Params{Volume: hoard.NewMemVolume()})
+	id, err := h.Add(ctx, strings.NewReader("data"))
+	require.NoError(t, err)
+	hexID := id.HexString()
+	for _, prefix := range []string{hexID, hexID[:hoard.MinPrefixLen], strings.ToUpper(hexID[:hoard.MinPrefixLen+1])} {
+		actual, err := h.ResolvePrefix(ctx, prefix)
+		require.NoError(t, err, prefix)
+		require.Equal(t, *id, actual)
+	}
+	for _, prefix := range []string{"", hexID[:hoard.MinPrefixLen-1], "zzzz", hexID[:hoard.MinPrefixLen] + "g", hexID + "0"} {
+		_, err := h.ResolvePrefix(ctx, prefix)
+		require.Error(t, err, prefix)
+	}
+	// the bad digit is reported, even when it is an odd last digit.
+	_, err = h.ResolvePrefix(ctx, hexID[:hoard.MinPrefixLen]+"g")
+	require.ErrorContains(t, err, "'g' at position 4")
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	store := hoard.NewMemVolume().Corpus
+	title := "base"
+	newHoard := func() *hoard.Hoard {
+		return hoard.New(hoard.Params{
+			Volume: hoard.Volume{Cell: cells.NewMem(1 << 16), Corpus: store, Index: store, GLFS: store},
+			Indexers: map[string]hoard.Indexer{
+				"tags": func(context.Context, hexpr.Expr, hexpr.Value) ([]labels.Pair, error) {
+					return []labels.Pair{{Key: "title", Value: []byte(title)}}, nil
+				},
+			},
+		})
+	}
+	add := func(h *hoard.Hoard, data string) hoard.ID {
+		id, err := h.Add(ctx, strings.NewReader(data))
+		require.NoError(t, err)
+		return *id
+	}
+	getTitle := func(h *hoard.Hoard, id hoard.ID) string {
+		ls, err := h.GetLabels(ctx, id, "tags")
+		require.NoError(t, err)
+		require.Len(t, ls, 1)
+		return string(ls[0].Value)
+	}
+
+	hBase := newHoard()
+	id0 := add(hBase, "zero")
+	base, err := hBase.GetState(ctx)
+	require.NoError(t, err)
+
+	// both sides add to the corpus, and relabel the object from base differently.
+	ours, theirs := newHoard(), newHoard()
+	for _, h := range []*hoard.Hoard{ours, theirs} {
+		_, err := h.Merge(ctx, hoard.State{}, *base)
+		require.NoError(t, err)
+	}
+	title = "ours"
+	add(ours, "zero")
+	id1 := add(ours, "one")
+	title = "theirs"
+	add(theirs, "zero")
+	id2 := add(theirs, "two")
+	other, err := theirs.GetState(ctx)
+	require.NoError(t, err)
+
+	conflicts, err := ours.Merge(ctx, *base, *other)
+	require.NoError(t, err)
+	require.Equal(t, []hoard.Conflict{{
+		ID:     id0,
+		Index:  "tags",
+		Key:    "title",
+		Base:   []byte("base"),
+		Ours:   []byte("ours"),
+		Theirs: []byte("theirs"),
+	}}, conflicts)
+
+	ids, err := ours.ListIDs(ctx, hoard.IDSpan{})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []hoard.ID{id0, id1, id2}, ids)
+	require.Equal(t, "ours", getTitle(ours, id0))
+	require.Equal(t, "ours", getTitle(ours, id1))
+	require.Equal(t, "theirs", getTitle(ours, id2))
+}
+
+func TestGetAllLabels(t *testing.T) {
+	ctx := context.Background()
+	indexer := func(ls ...labels.Pair) hoard.Indexer {
+		return func(context.Context, hexpr.Expr, hexpr.Value) ([]labels.Pair, error) {
+			return ls, nil
+		}
+	}
+	h := hoard.New(hoard.Params{
+		Volume: hoard.NewMemVolume(),
+		Indexers: map[string]hoard.Indexer{
+			"a": indexer(labels.Pair{Key: "title", Value: []byte("short")}, labels.Pair{Key: "year", Value: []byte("2000")}),
+			"b": indexer(labels.Pair{Key: "title", Value: []byte("longer title")}),
+			"c": indexer(labels.Pair{Key: "title", Value: []byte("other")}),
+		},
+		LabelPrecedence: map[string][]string{"title": {"b"}},
+	})
+	id, err := h.Add(ctx, strings.NewReader("data"))
+	require.NoError(t, err)
+	ls, err := h.GetAllLabels(ctx, *id)
+	require.NoError(t, err)
+	require.Equal(t, []hoard.Label{
+		{Index: "b", Pair: labels.Pair{Key: "title", Value: []byte("longer title")}},
+		{Index: "a", Pair: labels.Pair{Key: "title", Value: []byte("short")}},
+		{Index: "c", Pair: labels.Pair{Key: "title", Value: []byte("other")}},
+		{Index: "a", Pair: labels.Pair{Key: "year", Value: []byte("2000")}},
+	}, ls)
+	require.Equal(t, []labels.Pair{
+		{Key: "title", Value: []byte("longer title")},
+		{Key: "year", Value: []byte("2000")},
+	}, hoard.PreferredLabels(ls))
+
+	x, err := h.GetState(ctx)
+	require.NoError(t, err)
+	ls2, err := h.GetAllLabelsAt(ctx, x, *id)
+	require.NoError(t, err)
+	require.Equal(t, ls, ls2)
+}
+
+func TestPreferredLabels(t *testing.T) {
+	label := func(index, key, value string) hoard.Label {
+		return hoard.Label{Index: index, Pair: labels.Pair{Key: key, Value: []byte(value)}}
+	}
+	ls := []hoard.Label{
+		label("flac", "artist", "a"),
+		label("id3v2", "artist", "a & b"),
+		label("id3v1", "artist", "a &"),
+		label("id3v2", "title", "t"),
+	}
+	require.Equal(t, []labels.Pair{
+		{Key: "artist", Value: []byte("a")},
+		{Key: "title", Value: []byte("t")},
+	}, hoard.PreferredLabels(ls))
+}
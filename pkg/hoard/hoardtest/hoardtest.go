@@ -0,0 +1,142 @@
+// Package hoardtest provides a test suite for Hoards, which can be run against any Volume.
+package hoardtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hexpr"
+	"github.com/brendoncarroll/hoard/pkg/hoard"
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+// IndexName is the name of the index produced by the indexer used in the suite.
+const IndexName = "test"
+
+// TestVolume runs the suite against Hoards using Volumes from newVolume.
+// Each Volume returned by newVolume must be empty.
+func TestVolume(t *testing.T, newVolume func(t testing.TB) hoard.Volume) {
+	ctx := context.Background()
+	newHoard := func(t testing.TB) *hoard.Hoard {
+		return hoard.New(hoard.Params{
+			Volume:   newVolume(t),
+			Indexers: map[string]hoard.Indexer{IndexName: indexTest},
+		})
+	}
+	t.Run("Empty", func(t *testing.T) {
+		h := newHoard(t)
+		names, err := h.ListIndexes(ctx)
+		require.NoError(t, err)
+		require.Len(t, names, 0)
+		ids, err := h.ListIDs(ctx, hoard.IDSpan{})
+		require.NoError(t, err)
+		require.Len(t, ids, 0)
+		ids, err = h.Search(ctx, labels.Query{})
+		require.NoError(t, err)
+		require.Len(t, ids, 0)
+	})
+	t.Run("AddRead", func(t *testing.T) {
+		h := newHoard(t)
+		for i, size := range []int{1, 100, 4096, 4097, 1 << 17} {
+			data := make([]byte, size)
+			rand.New(rand.NewSource(int64(i))).Read(data)
+			id, err := h.Add(ctx, bytes.NewReader(data))
+			require.NoError(t, err, "test case %d", i)
+			r, err := h.NewReader(ctx, *id)
+			require.NoError(t, err, "test case %d", i)
+			actual, err := io.ReadAll(r)
+			require.NoError(t, err, "test case %d", i)
+			require.Equal(t, data, actual, "test case %d", i)
+		}
+		ids, err := h.ListIDs(ctx, hoard.IDSpan{})
+		require.NoError(t, err)
+		require.Len(t, ids, 5)
+	})
+	t.Run("Labels", func(t *testing.T) {
+		h := newHoard(t)
+		id, err := h.Add(ctx, bytes.NewReader([]byte("hello\nworld")))
+		require.NoError(t, err)
+		names, err := h.ListIndexes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{IndexName}, names)
+		ls, err := h.GetLabels(ctx, *id, IndexName)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []labels.Pair{
+			{Key: "first_line", Value: []byte("hello")},
+			{Key: "size", Value: []byte("11")},
+		}, ls)
+		_, err = h.GetLabels(ctx, *id, "not-an-index")
+		require.Error(t, err)
+		// the empty index name is every index.
+		ls, err = h.GetLabels(ctx, *id, "")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []labels.Pair{
+			{Key: "first_line", Value: []byte("hello")},
+			{Key: "size", Value: []byte("11")},
+		}, ls)
+		all, err := h.GetAllLabels(ctx, *id)
+		require.NoError(t, err)
+		require.Equal(t, []hoard.Label{
+			{Index: IndexName, Pair: labels.Pair{Key: "first_line", Value: []byte("hello")}},
+			{Index: IndexName, Pair: labels.Pair{Key: "size", Value: []byte("11")}},
+		}, all)
+	})
+	t.Run("Search", func(t *testing.T) {
+		h := newHoard(t)
+		const N = 10
+		ids := make([]hoard.ID, N)
+		for i := range ids {
+			id, err := h.Add(ctx, bytes.NewReader([]byte(fmt.Sprintf("doc %d\n", i))))
+			require.NoError(t, err)
+			ids[i] = *id
+		}
+		eq := func(k, v string) labels.Query {
+			return labels.Query{Where: labels.Predicate{Op: labels.OpEq, Key: k, Value: v}}
+		}
+		tcs := []struct {
+			Query    labels.Query
+			Expected []hoard.ID
+		}{
+			{Query: labels.Query{}, Expected: ids},
+			{Query: eq("first_line", "doc 3"), Expected: ids[3:4]},
+			{Query: eq("first_line", "doc 10")},
+			{Query: labels.Query{Where: labels.Predicate{Op: labels.OpPrefix, Key: "first_line", Value: "doc"}}, Expected: ids},
+		}
+		for i, tc := range tcs {
+			// twice, the second time may be served from the query cache.
+			for j := 0; j < 2; j++ {
+				actual, err := h.Search(ctx, tc.Query)
+				require.NoError(t, err, "test case %d", i)
+				require.ElementsMatch(t, tc.Expected, actual, "test case %d", i)
+			}
+		}
+	})
+}
+
+// indexTest labels a value with its first line and its size.
+func indexTest(ctx context.Context, e hexpr.Expr, v hexpr.Value) ([]labels.Pair, error) {
+	if e.IsMutable() || v.Type != "" {
+		return nil, nil
+	}
+	data, err := io.ReadAll(v.NewReader())
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	sc.Scan()
+	ret := []labels.Pair{{Key: "size", Value: []byte(strconv.Itoa(len(data)))}}
+	if line := sc.Bytes(); len(line) > 0 && utf8.Valid(line) && bytes.IndexByte(line, 0) < 0 {
+		ret = append(ret, labels.Pair{Key: "first_line", Value: append([]byte{}, line...)})
+	}
+	return ret, nil
+}
@@ -11,7 +11,10 @@ import (
 	"github.com/gotvc/got/pkg/gotfs"
 	"github.com/pkg/errors"
 
+	"github.com/brendoncarroll/hoard/pkg/cryptocell"
 	"github.com/brendoncarroll/hoard/pkg/filecell"
+	"github.com/brendoncarroll/hoard/pkg/httpvol"
+	"github.com/brendoncarroll/hoard/pkg/signedcell"
 )
 
 type VolumeSpec struct {
@@ -22,8 +25,10 @@ type VolumeSpec struct {
 }
 
 type CellSpec struct {
-	File *string       `json:"file,omitempty"`
-	HTTP *HTTPCellSpec `json:"http,omitempty"`
+	File      *string            `json:"file,omitempty"`
+	HTTP      *HTTPCellSpec      `json:"http,omitempty"`
+	Encrypted *EncryptedCellSpec `json:"encrypted,omitempty"`
+	Signed    *SignedCellSpec    `json:"signed,omitempty"`
 }
 
 type HTTPCellSpec struct {
@@ -31,8 +36,33 @@ type HTTPCellSpec struct {
 	Headers map[string]string `json:"headers,omitempty"`
 }
 
+// EncryptedCellSpec is a cell which encrypts the contents of Inner.
+// The key is derived from Passphrase, or read from the file at KeyFile; exactly one must be set.
+type EncryptedCellSpec struct {
+	Inner      CellSpec `json:"inner"`
+	Passphrase *string  `json:"passphrase,omitempty"`
+	KeyFile    *string  `json:"key_file,omitempty"`
+}
+
+// SignedCellSpec is a cell which checks that the contents of Inner are signed by PublicKey, which is hex encoded.
+// If PrivateKeyFile is set, the cell can be written, and the contents are signed with that key.
+// Otherwise the cell is read-only.
+type SignedCellSpec struct {
+	Inner          CellSpec `json:"inner"`
+	PublicKey      string   `json:"public_key"`
+	PrivateKeyFile *string  `json:"private_key_file,omitempty"`
+}
+
 type StoreSpec struct {
-	LocalDir *string `json:"local_dir,omitempty"`
+	LocalDir *string        `json:"local_dir,omitempty"`
+	HTTP     *HTTPStoreSpec `json:"http,omitempty"`
+}
+
+// HTTPStoreSpec is a store served by `hoard serve-volume`.
+// URL is the URL of the server, not of the store within it.
+type HTTPStoreSpec struct {
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 func MakeVolume(spec VolumeSpec) (*Volume, error) {
@@ -44,13 +74,15 @@ func MakeVolume(spec VolumeSpec) (*Volume, error) {
 	if err != nil {
 		return nil, err
 	}
-	glfsStore, err := MakeStore(spec.CorpusStore)
+	glfsStore, err := MakeStore(spec.GLFSStore)
 	if err != nil {
 		return nil, err
 	}
+	// the index is stored with the corpus, as it is for a local hoard.
 	return &Volume{
 		Cell:   cell,
 		Corpus: corpusStore,
+		Index:  corpusStore,
 		GLFS:   glfsStore,
 	}, nil
 }
@@ -62,12 +94,51 @@ func MakeCell(spec CellSpec) (cells.Cell, error) {
 		if err != nil {
 			return nil, err
 		}
-		return filecell.New(posixfs.NewOSFS(), filepath.ToSlash(p)), nil
+		return filecell.New(p), nil
 	case spec.HTTP != nil:
 		return httpcell.New(httpcell.Spec{
 			URL:     spec.HTTP.URL,
 			Headers: spec.HTTP.Headers,
 		}), nil
+	case spec.Encrypted != nil:
+		inner, err := MakeCell(spec.Encrypted.Inner)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case spec.Encrypted.Passphrase != nil && spec.Encrypted.KeyFile != nil:
+			return nil, errors.Errorf("encrypted cell spec has both a passphrase and a key file")
+		case spec.Encrypted.Passphrase != nil:
+			return cryptocell.NewWithPassphrase(inner, []byte(*spec.Encrypted.Passphrase)), nil
+		case spec.Encrypted.KeyFile != nil:
+			key, err := cryptocell.ReadKeyFile(*spec.Encrypted.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			return cryptocell.NewWithKey(inner, key), nil
+		default:
+			return nil, errors.Errorf("encrypted cell spec needs a passphrase or a key file")
+		}
+	case spec.Signed != nil:
+		inner, err := MakeCell(spec.Signed.Inner)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := signedcell.ParsePublicKey(spec.Signed.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Signed.PrivateKeyFile == nil {
+			return signedcell.NewReadOnly(inner, pub), nil
+		}
+		priv, err := signedcell.ReadPrivateKeyFile(*spec.Signed.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pub.Equal(priv.Public()) {
+			return nil, errors.Errorf("private key does not match public key %s", spec.Signed.PublicKey)
+		}
+		return signedcell.New(inner, priv), nil
 	default:
 		return nil, errors.Errorf("empty cell spec")
 	}
@@ -78,6 +149,12 @@ func MakeStore(spec StoreSpec) (cadata.Store, error) {
 	case spec.LocalDir != nil:
 		fs := posixfs.NewDirFS(*spec.LocalDir)
 		return fsstore.New(fs, cadata.DefaultHash, gotfs.DefaultMaxBlobSize), nil
+	case spec.HTTP != nil:
+		return httpvol.NewStore(httpvol.StoreSpec{
+			URL:     spec.HTTP.URL,
+			Headers: spec.HTTP.Headers,
+			MaxSize: gotfs.DefaultMaxBlobSize,
+		}), nil
 	default:
 		return nil, errors.Errorf("empty store spec")
 	}
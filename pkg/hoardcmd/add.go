@@ -6,8 +6,15 @@ import (
 	"github.com/brendoncarroll/go-state/posixfs"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
+func init() {
+	addCmd.Flags().Bool("tree", false, "import a directory as a single tree, preserving its structure")
+	addCmd.Flags().Bool("expand-archives", false, "also add each file in zip and tar archives")
+}
+
 var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Adds a file or every file in a directory individually",
@@ -16,7 +23,27 @@ var addCmd = &cobra.Command{
 		target := args[0]
 		fs := posixfs.NewOSFS()
 		w := cmd.OutOrStdout()
+		asTree, err := cmd.Flags().GetBool("tree")
+		if err != nil {
+			return err
+		}
+		expand, err := cmd.Flags().GetBool("expand-archives")
+		if err != nil {
+			return err
+		}
 		logrus.Infof("importing %s ...\n", target)
+		if asTree {
+			ids, err := h.AddTree(ctx, fs, target)
+			if err != nil {
+				return err
+			}
+			paths := maps.Keys(ids)
+			slices.Sort(paths)
+			for _, p := range paths {
+				fmt.Fprintf(w, "%v /%s\n", ids[p], p)
+			}
+			return nil
+		}
 		return posixfs.WalkLeaves(ctx, fs, target, func(p string, de posixfs.DirEnt) error {
 			f, err := fs.OpenFile(p, posixfs.O_RDONLY, 0)
 			if err != nil {
@@ -28,6 +55,18 @@ var addCmd = &cobra.Command{
 				return err
 			}
 			fmt.Fprintf(w, "%v %s\n", fp, p)
+			if !expand {
+				return nil
+			}
+			members, err := h.ExpandArchive(ctx, *fp)
+			if err != nil {
+				return err
+			}
+			paths := maps.Keys(members)
+			slices.Sort(paths)
+			for _, p2 := range paths {
+				fmt.Fprintf(w, "%v %s/%s\n", members[p2], p, p2)
+			}
 			return nil
 		})
 	},
@@ -1,12 +1,13 @@
 package hoardcmd
 
 import (
-	"encoding/hex"
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
 
-	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/brendoncarroll/hoard/pkg/hexpr"
 	"github.com/brendoncarroll/hoard/pkg/hoard"
-	"github.com/gotvc/got/pkg/gotkv"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -19,32 +20,56 @@ var catCmd = &cobra.Command{
 			return errors.Errorf("must provide fingerprint")
 		}
 		w := cmd.OutOrStdout()
-		prefixHex := args[0]
-		if len(prefixHex)%2 != 0 {
-			prefixHex = prefixHex[:len(prefixHex)-1]
-		}
-		prefix, err := hex.DecodeString(prefixHex)
+		id, err := h.ResolvePrefix(ctx, args[0])
 		if err != nil {
 			return err
 		}
-		span := hoard.IDSpan{}
-		span = span.WithLowerIncl(cadata.IDFromBytes(prefix))
-		span = span.WithUpperExcl(cadata.IDFromBytes(gotkv.PrefixEnd(prefix)))
-		ids, err := h.ListIDs(ctx, span)
+		v, err := h.Eval(ctx, id)
 		if err != nil {
 			return err
 		}
-		if len(ids) == 0 {
-			return errors.Errorf("not found")
-		}
-		if len(ids) > 1 {
-			return errors.Errorf("prefix is non-specific. try a longer one.")
-		}
-		r, err := h.NewReader(ctx, ids[0])
-		if err != nil {
+		switch v.Type {
+		case "List[ID]", "Set[ID]":
+			// lists, sets and saved queries are written as one ID per line
+			bw := bufio.NewWriter(w)
+			if err := v.ForEach(func(id hoard.ID) error {
+				_, err := fmt.Fprintf(bw, "%v\n", id)
+				return err
+			}); err != nil {
+				return err
+			}
+			return bw.Flush()
+		case "Dir":
+			var ents []hexpr.DirEntry
+			if err := json.NewDecoder(v.NewReader()).Decode(&ents); err != nil {
+				return err
+			}
+			bw := bufio.NewWriter(w)
+			for _, ent := range ents {
+				name := ent.Name
+				if ent.Mode.IsDir() {
+					name += "/"
+				}
+				if _, err := fmt.Fprintf(bw, "%v %s\n", ent.ID, name); err != nil {
+					return err
+				}
+			}
+			return bw.Flush()
+		default:
+			_, err = io.Copy(w, v.NewReader())
 			return err
 		}
-		_, err = io.Copy(w, r)
-		return err
 	},
 }
+
+// resolveIDs resolves each of args as an ID prefix
+func resolveIDs(args []string) ([]hoard.ID, error) {
+	ids := make([]hoard.ID, len(args))
+	for i, arg := range args {
+		var err error
+		if ids[i], err = h.ResolvePrefix(ctx, arg); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,89 @@
+package hoardcmd
+
+import (
+	"bufio"
+	"fmt"
+
+	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/brendoncarroll/hoard/pkg/hexpr"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	collectionCreateCmd.Flags().Bool("set", false, "create a set instead of a list")
+	collectionCmd.AddCommand(collectionCreateCmd)
+	collectionCmd.AddCommand(collectionAppendCmd)
+	collectionCmd.AddCommand(collectionLsCmd)
+}
+
+var collectionCmd = &cobra.Command{
+	Use:   "collection",
+	Short: "create and modify lists and sets of objects",
+}
+
+var collectionCreateCmd = &cobra.Command{
+	Use:   "create <id>...",
+	Short: "creates a collection from objects, and writes its ID to stdout",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		isSet, err := cmd.Flags().GetBool("set")
+		if err != nil {
+			return err
+		}
+		kind := hexpr.KindList
+		if isSet {
+			kind = hexpr.KindSet
+		}
+		ids, err := resolveIDs(args)
+		if err != nil {
+			return err
+		}
+		id, err := h.CreateCollection(ctx, kind, ids)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%v\n", id)
+		return err
+	},
+}
+
+var collectionAppendCmd = &cobra.Command{
+	Use:   "append <collection> <id>...",
+	Short: "appends objects to a collection, and writes the ID of the new collection to stdout",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := resolveIDs(args)
+		if err != nil {
+			return err
+		}
+		id, err := h.AppendToCollection(ctx, ids[0], ids[1:])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%v\n", id)
+		return err
+	},
+}
+
+var collectionLsCmd = &cobra.Command{
+	Use:   "ls <collection>",
+	Short: "lists the members of a collection",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := h.ResolvePrefix(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		v, err := h.Eval(ctx, id)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(cmd.OutOrStdout())
+		if err := v.ForEach(func(id hcorpus.ID) error {
+			_, err := fmt.Fprintf(w, "%v\n", id)
+			return err
+		}); err != nil {
+			return err
+		}
+		return w.Flush()
+	},
+}
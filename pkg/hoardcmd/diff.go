@@ -0,0 +1,116 @@
+package hoardcmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"github.com/brendoncarroll/hoard/pkg/hoard"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot <path>",
+	Short: "saves the current state to a file, to compare with later states using diff",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		x, err := h.GetState(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(x, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(args[0], data, 0o644)
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old-state> [<new-state>]",
+	Short: "lists the changes from a saved state to another saved state, or to the current state",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := loadState(args[0])
+		if err != nil {
+			return err
+		}
+		var b *hoard.State
+		if len(args) > 1 {
+			b, err = loadState(args[1])
+		} else {
+			b, err = h.GetState(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(cmd.OutOrStdout())
+		if err := h.Diff(ctx, *a, *b, func(d hoard.Delta) error {
+			var op byte
+			switch {
+			case d.Old == nil:
+				op = '+'
+			case d.New == nil:
+				op = '-'
+			default:
+				op = '~'
+			}
+			var err error
+			switch {
+			case d.Index == "":
+				_, err = fmt.Fprintf(w, "%c %v\n", op, d.ID)
+			case op == '~':
+				_, err = fmt.Fprintf(w, "%c %v %s.%s %q -> %q\n", op, d.ID, d.Index, d.Key, d.Old, d.New)
+			case op == '+':
+				_, err = fmt.Fprintf(w, "%c %v %s.%s %q\n", op, d.ID, d.Index, d.Key, d.New)
+			default:
+				_, err = fmt.Fprintf(w, "%c %v %s.%s %q\n", op, d.ID, d.Index, d.Key, d.Old)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+		return w.Flush()
+	},
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <base-state> <other-state>",
+	Short: "merges the changes from a saved base state to another saved state into the current state",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base, err := loadState(args[0])
+		if err != nil {
+			return err
+		}
+		other, err := loadState(args[1])
+		if err != nil {
+			return err
+		}
+		conflicts, err := h.Merge(ctx, *base, *other)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(cmd.OutOrStdout())
+		for _, c := range conflicts {
+			if _, err := fmt.Fprintf(w, "conflict %v %s.%s base=%q ours=%q theirs=%q\n", c.ID, c.Index, c.Key, c.Base, c.Ours, c.Theirs); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func loadState(p string) (*hoard.State, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var x hoard.State
+	if err := json.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
@@ -12,3 +12,17 @@ func DefaultIndexers() map[string]hoard.Indexer {
 		"flac":  hidx_audio.IndexFLAC,
 	}
 }
+
+// DefaultLabelPrecedence prefers FLAC metadata, then ID3v2, and then ID3v1, which truncates values.
+// Only the keys produced by more than one of the DefaultIndexers are listed.
+// FLAC uses the Vorbis comment names, so its album artist and track number are under other keys.
+func DefaultLabelPrecedence() map[string][]string {
+	ret := make(map[string][]string)
+	for _, key := range []string{"title", "album", "artist", "composer", "genre"} {
+		ret[key] = []string{"flac", "id3v2", "id3v1"}
+	}
+	for _, key := range []string{"album_artist", "track", "tag_format"} {
+		ret[key] = []string{"id3v2", "id3v1"}
+	}
+	return ret
+}
@@ -0,0 +1,34 @@
+package hoardcmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+
+	"github.com/brendoncarroll/hoard/pkg/hoard"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "re-encodes objects stored in an old format, and writes their old and new IDs to stdout",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mapping, err := h.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		ids := maps.Keys(mapping)
+		slices.SortFunc(ids, func(a, b hoard.ID) bool {
+			return bytes.Compare(a[:], b[:]) < 0
+		})
+		w := bufio.NewWriter(cmd.OutOrStdout())
+		for _, id := range ids {
+			fmt.Fprintf(w, "%v %v\n", id, mapping[id])
+		}
+		return w.Flush()
+	},
+}
@@ -27,15 +27,19 @@ var lsExprCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "lists expressions and their labels",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		x, err := h.GetState(ctx)
+		if err != nil {
+			return err
+		}
 		w := bufio.NewWriter(cmd.OutOrStdout())
 		if err := h.ForEachExpr(ctx, hoard.IDSpan{}, func(id hoard.ID, e hoard.Expr) error {
-			ls, err := h.GetLabels(ctx, id, "")
+			ls, err := h.GetAllLabelsAt(ctx, x, id)
 			if err != nil {
 				return err
 			}
 			fmt.Fprintf(w, "%v\n", id)
-			for _, pair := range ls {
-				fmt.Fprintf(w, "\t%v\n", pair)
+			for _, l := range ls {
+				fmt.Fprintf(w, "\t%v\t%s\n", l.Pair, l.Index)
 			}
 			return nil
 		}); err != nil {
@@ -44,3 +48,21 @@ var lsExprCmd = &cobra.Command{
 		return w.Flush()
 	},
 }
+
+var statCmd = &cobra.Command{
+	Use:   "stat <id>",
+	Short: "writes the MIME type and size of an object to stdout",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := h.ResolvePrefix(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		st, err := h.Stat(ctx, id)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s %d\n", st.MIMEType, st.Size)
+		return err
+	},
+}
@@ -16,6 +16,7 @@ import (
 
 var (
 	ctx = context.Background()
+	vol hoard.Volume
 	h   *hoard.Hoard
 )
 
@@ -31,6 +32,15 @@ func init() {
 	rootCmd.AddCommand(lsIDCmd)
 	rootCmd.AddCommand(lsExprCmd)
 	rootCmd.AddCommand(lsTagValuesCmd)
+	rootCmd.AddCommand(collectionCmd)
+	rootCmd.AddCommand(saveQueryCmd)
+	rootCmd.AddCommand(sliceCmd)
+	rootCmd.AddCommand(statCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(serveVolumeCmd)
 }
 
 var rootCmd = &cobra.Command{
@@ -56,16 +66,21 @@ func setup() (err error) {
 	if err := posixfs.MkdirAll(workingDir, "hoard_data/blobs", 0o755); err != nil {
 		return err
 	}
-	cell := filecell.New(workingDir, "hoard_data/CELL")
+	cell := filecell.New(filepath.Join(dir, "hoard_data", "CELL"))
 	storeFS := posixfs.NewPrefixed(workingDir, "hoard_data/blobs")
 	store := fsstore.New(storeFS, cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
+	vol = hoard.Volume{
+		Cell:   cell,
+		Corpus: store,
+		Index:  store,
+		GLFS:   store,
+
+		QueryCache: filecell.New(filepath.Join(dir, "hoard_data", "QUERY_CACHE")),
+	}
 	h = hoard.New(hoard.Params{
-		Volume: hoard.Volume{
-			Cell:   cell,
-			Corpus: store,
-			Index:  store,
-			GLFS:   store,
-		},
+		Volume:          vol,
+		Indexers:        DefaultIndexers(),
+		LabelPrecedence: DefaultLabelPrecedence(),
 	})
 	return nil
 }
@@ -5,17 +5,28 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/brendoncarroll/hoard/pkg/hoard"
 	"github.com/brendoncarroll/hoard/pkg/labels"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	searchCmd.Flags().BoolP("ignore-case", "i", false, "match values regardless of case")
+	saveQueryCmd.Flags().BoolP("ignore-case", "i", false, "match values regardless of case")
+	saveQueryCmd.Flags().Int("limit", 0, "the maximum number of IDs the query evaluates to, 0 for no limit")
+}
+
 var searchCmd = &cobra.Command{
 	Use:   "search",
 	Short: "search for content by tags",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pred, err := parsePredicate(args)
+		ignoreCase, err := cmd.Flags().GetBool("ignore-case")
+		if err != nil {
+			return err
+		}
+		pred, err := parsePredicate(args, ignoreCase)
 		if err != nil {
 			return err
 		}
@@ -28,13 +39,17 @@ var searchCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		x, err := h.GetState(ctx)
+		if err != nil {
+			return err
+		}
 		w := bufio.NewWriter(cmd.OutOrStdout())
 		for _, id := range res {
-			tags, err := h.GetLabels(ctx, id, "")
+			ls, err := h.GetAllLabelsAt(ctx, x, id)
 			if err != nil {
 				return err
 			}
-			if _, err := fmt.Fprintf(w, "%v\t%v\n", id.String()[:8], tags); err != nil {
+			if _, err := fmt.Fprintf(w, "%v\t%v\n", id.String()[:8], hoard.PreferredLabels(ls)); err != nil {
 				return err
 			}
 		}
@@ -42,27 +57,92 @@ var searchCmd = &cobra.Command{
 	},
 }
 
-func parsePredicate(args []string) (*labels.Predicate, error) {
+// parsePredicate parses each argument into a predicate.
+// The arguments are of the form:
+//
+//	key=value    equal
+//	key^=prefix  prefix
+//	key~=regexp  regular expression
+//	has:key      the key exists
+//	missing:key  the key does not exist
+func parsePredicate(args []string, ignoreCase bool) (*labels.Predicate, error) {
 	var subQueries []labels.Query
 	for _, arg := range args {
+		var pred labels.Predicate
 		switch {
+		case strings.HasPrefix(arg, "has:"):
+			pred = labels.Predicate{
+				Op:  labels.OpExists,
+				Key: strings.TrimPrefix(arg, "has:"),
+			}
+		case strings.HasPrefix(arg, "missing:"):
+			pred = labels.Predicate{
+				Op:  labels.OpMissing,
+				Key: strings.TrimPrefix(arg, "missing:"),
+			}
 		case strings.Contains(arg, "="):
-			parts := strings.SplitN(arg, "=", 2)
-			q := labels.Query{
-				Where: labels.Predicate{
+			// the operator is at the first '=', so values may contain operators.
+			i := strings.Index(arg, "=")
+			key, value := arg[:i], arg[i+1:]
+			switch {
+			case strings.HasSuffix(key, "^"):
+				pred = labels.Predicate{
+					Op:    labels.OpPrefix,
+					Key:   strings.TrimSuffix(key, "^"),
+					Value: value,
+				}
+			case strings.HasSuffix(key, "~"):
+				// regexps are matched as they are, so case is ignored with a flag.
+				if ignoreCase {
+					value = "(?i)" + value
+				}
+				pred = labels.Predicate{
+					Op:    labels.OpRegexp,
+					Key:   strings.TrimSuffix(key, "~"),
+					Value: value,
+				}
+			default:
+				pred = labels.Predicate{
 					Op:    labels.OpEq,
-					Key:   parts[0],
-					Value: parts[1],
-				},
-				Limit: 100,
+					Key:   key,
+					Value: value,
+				}
 			}
-			subQueries = append(subQueries, q)
 		default:
 			return nil, errors.Errorf("could not parse into predicate %q", arg)
 		}
+		pred.CaseInsensitive = ignoreCase
+		// sub-queries are not limited, only the query as a whole.
+		subQueries = append(subQueries, labels.Query{Where: pred})
 	}
 	return &labels.Predicate{
 		Op:         labels.OpOR,
 		SubQueries: subQueries,
 	}, nil
 }
+
+var saveQueryCmd = &cobra.Command{
+	Use:   "save-query <name> <predicate>...",
+	Short: "saves a search as an object, which evaluates to the IDs matching it",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ignoreCase, err := cmd.Flags().GetBool("ignore-case")
+		if err != nil {
+			return err
+		}
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			return err
+		}
+		pred, err := parsePredicate(args[1:], ignoreCase)
+		if err != nil {
+			return err
+		}
+		id, err := h.SaveQuery(ctx, args[0], labels.Query{Where: *pred, Limit: limit})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%v\n", id)
+		return err
+	},
+}
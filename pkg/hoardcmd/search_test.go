@@ -0,0 +1,37 @@
+package hoardcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/labels"
+)
+
+func TestParsePredicate(t *testing.T) {
+	tcs := []struct {
+		Arg        string
+		IgnoreCase bool
+		Expected   labels.Predicate
+	}{
+		{Arg: "title=abc", Expected: labels.Predicate{Op: labels.OpEq, Key: "title", Value: "abc"}},
+		{Arg: "title^=ab", Expected: labels.Predicate{Op: labels.OpPrefix, Key: "title", Value: "ab"}},
+		{Arg: "title~=^a.c$", Expected: labels.Predicate{Op: labels.OpRegexp, Key: "title", Value: "^a.c$"}},
+		{Arg: "title=a^=b", Expected: labels.Predicate{Op: labels.OpEq, Key: "title", Value: "a^=b"}},
+		{Arg: "title=a~=b", Expected: labels.Predicate{Op: labels.OpEq, Key: "title", Value: "a~=b"}},
+		{Arg: "title^=a=b", Expected: labels.Predicate{Op: labels.OpPrefix, Key: "title", Value: "a=b"}},
+		{Arg: "has:title", Expected: labels.Predicate{Op: labels.OpExists, Key: "title"}},
+		{Arg: "missing:title", Expected: labels.Predicate{Op: labels.OpMissing, Key: "title"}},
+		{Arg: "title=abc", IgnoreCase: true, Expected: labels.Predicate{Op: labels.OpEq, Key: "title", Value: "abc", CaseInsensitive: true}},
+		{Arg: "title~=abc", IgnoreCase: true, Expected: labels.Predicate{Op: labels.OpRegexp, Key: "title", Value: "(?i)abc", CaseInsensitive: true}},
+	}
+	for i, tc := range tcs {
+		pred, err := parsePredicate([]string{tc.Arg}, tc.IgnoreCase)
+		require.NoError(t, err, "test case %d", i)
+		require.Len(t, pred.SubQueries, 1, "test case %d", i)
+		require.Equal(t, tc.Expected, pred.SubQueries[0].Where, "test case %d", i)
+		require.Equal(t, 0, pred.SubQueries[0].Limit, "test case %d", i)
+	}
+	_, err := parsePredicate([]string{"title"}, false)
+	require.Error(t, err)
+}
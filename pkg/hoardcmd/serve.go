@@ -0,0 +1,36 @@
+package hoardcmd
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/brendoncarroll/hoard/pkg/httpvol"
+)
+
+func init() {
+	serveVolumeCmd.Flags().Bool("read-only", false, "reject requests which would change the hoard")
+}
+
+var serveVolumeCmd = &cobra.Command{
+	Use:   "serve-volume <addr>",
+	Short: "serves the hoard's cell and store over HTTP, so it can be opened from another machine",
+	Long: `serves the hoard's cell and store over HTTP, so it can be opened from another machine.
+Requests are not authenticated: anyone who can reach addr can read the hoard, and unless --read-only is set, change or delete it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		readOnly, err := cmd.Flags().GetBool("read-only")
+		if err != nil {
+			return err
+		}
+		var opts []httpvol.ServerOption
+		if readOnly {
+			opts = append(opts, httpvol.WithReadOnly())
+		}
+		// the index and glfs data are in the corpus store, see setup.
+		srv := httpvol.NewServer(vol.Cell, vol.Corpus, opts...)
+		logrus.Infof("serving volume on http://%s", args[0])
+		return http.ListenAndServe(args[0], srv)
+	},
+}
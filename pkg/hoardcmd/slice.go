@@ -0,0 +1,34 @@
+package hoardcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var sliceCmd = &cobra.Command{
+	Use:   "slice <id> <offset> <length>",
+	Short: "adds a range of bytes from an object as a new object, and writes its ID to stdout",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := h.ResolvePrefix(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		length, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		id2, err := h.AddSlice(ctx, id, offset, length)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%v\n", id2)
+		return err
+	},
+}
@@ -0,0 +1,67 @@
+package httpvol_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/cells/httpcell"
+	"github.com/gotvc/got/pkg/gotfs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brendoncarroll/hoard/pkg/hoard"
+	"github.com/brendoncarroll/hoard/pkg/hoard/hoardtest"
+	"github.com/brendoncarroll/hoard/pkg/httpvol"
+)
+
+func TestHoardSuite(t *testing.T) {
+	hoardtest.TestVolume(t, func(t testing.TB) hoard.Volume {
+		store := cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
+		srv := httptest.NewServer(httpvol.NewServer(cells.NewMem(httpcell.MaxSize), store))
+		t.Cleanup(srv.Close)
+		return makeVolume(t, srv.URL)
+	})
+}
+
+// TestRemoteHoard adds data to a hoard, serves its volume, and then opens it from the specs for the server.
+func TestRemoteHoard(t *testing.T) {
+	ctx := context.Background()
+	store := cadata.NewMem(cadata.DefaultHash, gotfs.DefaultMaxBlobSize)
+	cell := cells.NewMem(httpcell.MaxSize)
+	h1 := hoard.New(hoard.Params{Volume: hoard.Volume{Cell: cell, Corpus: store, Index: store, GLFS: store}})
+	id, err := h1.Add(ctx, strings.NewReader("hello world"))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(httpvol.NewServer(cell, store))
+	t.Cleanup(srv.Close)
+	h2 := hoard.New(hoard.Params{Volume: makeVolume(t, srv.URL)})
+
+	r, err := h2.NewReader(ctx, *id)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	// and write through the server
+	id2, err := h2.Add(ctx, strings.NewReader("hello again"))
+	require.NoError(t, err)
+	ids, err := h1.ListIDs(ctx, hoard.IDSpan{})
+	require.NoError(t, err)
+	require.Contains(t, ids, *id2)
+}
+
+// makeVolume opens the Volume served at u, from its spec.
+func makeVolume(t testing.TB, u string) hoard.Volume {
+	storeSpec := hoard.StoreSpec{HTTP: &hoard.HTTPStoreSpec{URL: u}}
+	vol, err := hoard.MakeVolume(hoard.VolumeSpec{
+		Cell:        hoard.CellSpec{HTTP: &hoard.HTTPCellSpec{URL: u + httpvol.CellPath}},
+		CorpusStore: storeSpec,
+		GLFSStore:   storeSpec,
+	})
+	require.NoError(t, err)
+	return *vol
+}
@@ -0,0 +1,78 @@
+package httpvol
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/brendoncarroll/go-state/cadata/storetest"
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/cells/celltest"
+	"github.com/brendoncarroll/go-state/cells/httpcell"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCell(t *testing.T) {
+	celltest.CellTestSuite(t, func(t testing.TB) cells.Cell {
+		srv := setup(t, cadata.NewMem(cadata.DefaultHash, cadata.DefaultMaxSize))
+		return httpcell.New(httpcell.Spec{URL: srv.URL + CellPath})
+	})
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func(t testing.TB) cadata.Store {
+		srv := setup(t, cadata.NewMem(cadata.DefaultHash, cadata.DefaultMaxSize))
+		return NewStore(StoreSpec{URL: srv.URL, MaxSize: cadata.DefaultMaxSize})
+	})
+}
+
+func TestExists(t *testing.T) {
+	ctx := context.Background()
+	srv := setup(t, cadata.NewMem(cadata.DefaultHash, cadata.DefaultMaxSize))
+	s := NewStore(StoreSpec{URL: srv.URL, MaxSize: cadata.DefaultMaxSize})
+	id, err := s.Post(ctx, []byte("hello"))
+	require.NoError(t, err)
+	exists, err := s.Exists(ctx, id)
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = s.Exists(ctx, s.Hash([]byte("world")))
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = cadata.GetBytes(ctx, s, s.Hash([]byte("world")))
+	require.ErrorIs(t, err, cadata.ErrNotFound)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	store := cadata.NewMem(cadata.DefaultHash, cadata.DefaultMaxSize)
+	id, err := store.Post(ctx, []byte("hello"))
+	require.NoError(t, err)
+	memCell := cells.NewMem(httpcell.MaxSize)
+	srv := httptest.NewServer(NewServer(memCell, store, WithReadOnly()))
+	t.Cleanup(srv.Close)
+
+	s := NewStore(StoreSpec{URL: srv.URL, MaxSize: cadata.DefaultMaxSize})
+	data, err := cadata.GetBytes(ctx, s, id)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	_, err = s.Post(ctx, []byte("world"))
+	require.Error(t, err)
+	require.Error(t, s.Delete(ctx, id))
+	exists, err := cadata.Exists(ctx, store, id)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	cell := httpcell.New(httpcell.Spec{URL: srv.URL + CellPath})
+	success, _, err := cell.CAS(ctx, make([]byte, cell.MaxSize()), nil, []byte("next"))
+	require.False(t, success && err == nil)
+	data, err = cells.GetBytes(ctx, memCell)
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+func setup(t testing.TB, store cadata.Store) *httptest.Server {
+	srv := httptest.NewServer(NewServer(cells.NewMem(httpcell.MaxSize), store))
+	t.Cleanup(srv.Close)
+	return srv
+}
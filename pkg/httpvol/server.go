@@ -0,0 +1,245 @@
+// Package httpvol serves a cell and a store over HTTP, so that a hoard can be opened from another machine.
+//
+// The cell is served at /cell, using the protocol expected by httpcell.
+// The store is served at /blobs/:
+//   - POST /blobs/ stores the request body, and responds with its ID.
+//   - GET /blobs/<id> responds with the data for id.
+//   - HEAD /blobs/<id> responds with 200 if id exists, and 404 if it does not.
+//   - DELETE /blobs/<id> deletes id.
+//   - GET /blobs/?gteq=<id>&lt=<id>&limit=<n> responds with the IDs in the range, one per line.
+//
+// IDs are encoded with cadata.Base64Alphabet.
+//
+// The server does not authenticate requests; anyone who can reach it can read, and unless it is read-only,
+// overwrite the cell and delete data. Serve it only on trusted networks, or behind a proxy which authenticates.
+package httpvol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/brendoncarroll/go-state/cadata"
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/cells/httpcell"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	CellPath  = "/cell"
+	BlobsPath = "/blobs/"
+
+	// maxList is the most IDs returned by a single list request.
+	maxList = 1 << 12
+)
+
+// Server is an http.Handler serving a cell and a store.
+type Server struct {
+	cell     cells.Cell
+	store    cadata.Store
+	readOnly bool
+
+	// mu serializes CASes on the cell, which compare hashes, rather than the contents.
+	mu sync.Mutex
+}
+
+type ServerOption func(s *Server)
+
+// WithReadOnly makes the Server reject requests which would change the cell or the store.
+func WithReadOnly() ServerOption {
+	return func(s *Server) {
+		s.readOnly = true
+	}
+}
+
+func NewServer(cell cells.Cell, store cadata.Store, opts ...ServerOption) *Server {
+	s := &Server{cell: cell, store: store}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.readOnly {
+		switch r.Method {
+		case http.MethodPut, http.MethodPost, http.MethodDelete:
+			http.Error(w, "server is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+	switch {
+	case r.URL.Path == CellPath:
+		s.serveCell(w, r)
+	case strings.HasPrefix(r.URL.Path, BlobsPath):
+		s.serveBlobs(w, r, strings.TrimPrefix(r.URL.Path, BlobsPath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveCell(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		data, err := cells.GetBytes(ctx, s.cell)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Write(data)
+	case http.MethodPut:
+		believed, err := base64.URLEncoding.DecodeString(r.Header.Get(httpcell.CurrentHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next, err := io.ReadAll(io.LimitReader(r.Body, int64(s.cell.MaxSize())+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(next) > s.cell.MaxSize() {
+			http.Error(w, "value is too large for cell", http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		prev, err := cells.GetBytes(ctx, s.cell)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data := prev
+		if actualHash := sha3.Sum256(prev); bytes.Equal(actualHash[:], believed) {
+			buf := make([]byte, s.cell.MaxSize())
+			_, n, err := s.cell.CAS(ctx, buf, prev, next)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			data = buf[:n]
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveBlobs(w http.ResponseWriter, r *http.Request, idStr string) {
+	ctx := r.Context()
+	if idStr == "" {
+		switch r.Method {
+		case http.MethodPost:
+			data, err := io.ReadAll(io.LimitReader(r.Body, int64(s.store.MaxSize())+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := s.store.Post(ctx, data)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Write([]byte(id.String()))
+		case http.MethodGet:
+			s.serveList(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	var id cadata.ID
+	if err := id.UnmarshalBase64([]byte(idStr)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		data, err := cadata.GetBytes(ctx, s.store, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Write(data)
+	case http.MethodHead:
+		exists, err := cadata.Exists(ctx, s.store, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodDelete:
+		if err := s.store.Delete(ctx, id); err != nil {
+			writeError(w, err)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	span := cadata.Span{}
+	if x := q.Get("gteq"); x != "" {
+		var id cadata.ID
+		if err := id.UnmarshalBase64([]byte(x)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		span = span.WithLowerIncl(id)
+	}
+	if x := q.Get("lt"); x != "" {
+		var id cadata.ID
+		if err := id.UnmarshalBase64([]byte(x)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		span = span.WithUpperExcl(id)
+	}
+	limit := maxList
+	if x := q.Get("limit"); x != "" {
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", x), http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	ids := make([]cadata.ID, limit)
+	n, err := s.store.List(r.Context(), span, ids)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var buf bytes.Buffer
+	for _, id := range ids[:n] {
+		buf.WriteString(id.String())
+		buf.WriteByte('\n')
+	}
+	w.Write(buf.Bytes())
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case cadata.IsNotFound(err):
+		code = http.StatusNotFound
+	case cadata.IsTooLarge(err):
+		code = http.StatusRequestEntityTooLarge
+	default:
+		logrus.Error(err)
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -0,0 +1,162 @@
+package httpvol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/brendoncarroll/go-state/cadata"
+)
+
+type StoreSpec struct {
+	// URL is the URL of the Server, the store is at BlobsPath under it.
+	URL     string
+	Headers map[string]string
+	MaxSize int
+}
+
+var _ cadata.Store = &Store{}
+
+// Store is a cadata.Store which is served by a Server.
+type Store struct {
+	spec StoreSpec
+	hc   *http.Client
+}
+
+func NewStore(spec StoreSpec) *Store {
+	spec.URL = strings.TrimSuffix(spec.URL, "/")
+	return &Store{
+		spec: spec,
+		hc:   http.DefaultClient,
+	}
+}
+
+func (s *Store) Post(ctx context.Context, data []byte) (cadata.ID, error) {
+	if len(data) > s.MaxSize() {
+		return cadata.ID{}, cadata.ErrTooLarge
+	}
+	body, err := s.do(ctx, http.MethodPost, s.blobURL(""), data)
+	if err != nil {
+		return cadata.ID{}, err
+	}
+	var id cadata.ID
+	if err := id.UnmarshalBase64(body); err != nil {
+		return cadata.ID{}, err
+	}
+	if id != s.Hash(data) {
+		return cadata.ID{}, fmt.Errorf("server returned wrong ID %v for data", id)
+	}
+	return id, nil
+}
+
+func (s *Store) Get(ctx context.Context, id cadata.ID, buf []byte) (int, error) {
+	body, err := s.do(ctx, http.MethodGet, s.blobURL(id.String()), nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := cadata.Check(s.Hash, id, body); err != nil {
+		return 0, err
+	}
+	if len(buf) < len(body) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(buf, body), nil
+}
+
+func (s *Store) Exists(ctx context.Context, id cadata.ID) (bool, error) {
+	_, err := s.do(ctx, http.MethodHead, s.blobURL(id.String()), nil)
+	if cadata.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) Delete(ctx context.Context, id cadata.ID) error {
+	_, err := s.do(ctx, http.MethodDelete, s.blobURL(id.String()), nil)
+	return err
+}
+
+func (s *Store) List(ctx context.Context, span cadata.Span, ids []cadata.ID) (int, error) {
+	q := url.Values{}
+	if _, ok := span.LowerBound(); ok {
+		q.Set("gteq", cadata.BeginFromSpan(span).String())
+	}
+	if upper, ok := span.UpperBound(); ok {
+		if !span.IncludesUpper() {
+			q.Set("lt", upper.String())
+		} else if suc := upper.Successor(); !suc.IsZero() {
+			q.Set("lt", suc.String())
+		}
+	}
+	q.Set("limit", strconv.Itoa(len(ids)))
+	body, err := s.do(ctx, http.MethodGet, s.blobURL("")+"?"+q.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		if n >= len(ids) {
+			return n, fmt.Errorf("server returned more than %d IDs", len(ids))
+		}
+		if err := ids[n].UnmarshalBase64(sc.Bytes()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+func (s *Store) Hash(x []byte) cadata.ID {
+	return cadata.DefaultHash(x)
+}
+
+func (s *Store) MaxSize() int {
+	return s.spec.MaxSize
+}
+
+func (s *Store) blobURL(id string) string {
+	return s.spec.URL + BlobsPath + id
+}
+
+// do sends a request, and returns the response body.
+// A 404 response is returned as cadata.ErrNotFound.
+func (s *Store) do(ctx context.Context, method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.spec.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// the body is either a blob, or a list of at most maxList IDs, each of which is less than 64 bytes.
+	limit := s.MaxSize()
+	if limit < maxList*64 {
+		limit = maxList * 64
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, cadata.ErrNotFound
+	case http.StatusRequestEntityTooLarge:
+		return nil, cadata.ErrTooLarge
+	default:
+		return nil, fmt.Errorf("%s %s: %v %s", method, u, resp.Status, bytes.TrimSpace(data))
+	}
+}
@@ -31,7 +31,11 @@ func IndexFLAC(ctx context.Context, e hexpr.Expr, cv hexpr.Value) ([]Tag, error)
 	if e.IsMutable() {
 		return nil, nil
 	}
-	return ParseFLAC(nil, cv.NewReader())
+	r := cv.NewReader()
+	if ok, err := IsFLAC(r); err != nil || !ok {
+		return nil, err
+	}
+	return ParseFLAC(nil, r)
 }
 
 func ParseID3v1(out []Tag, r io.ReadSeeker) ([]Tag, error) {
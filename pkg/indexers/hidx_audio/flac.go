@@ -1,6 +1,8 @@
 package hidx_audio
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -42,3 +44,34 @@ func fromVorbis(comment *meta.VorbisComment, tags []Tag) []Tag {
 	}
 	return tags
 }
+
+// IsFLAC returns true if r starts with the FLAC signature, after any ID3v2 tag.
+// FLAC files with an ID3v2 tag are sniffed as MP3s, so the MIME type cannot be used to find them.
+// r is left at the start.
+func IsFLAC(r io.ReadSeeker) (bool, error) {
+	var header [10]byte
+	n, err := io.ReadFull(r, header[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	var sig []byte
+	if n == len(header) && bytes.HasPrefix(header[:], []byte("ID3")) {
+		// the size of the tag is a syncsafe integer, and does not include the header.
+		size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		if _, err := r.Seek(int64(len(header))+size, io.SeekStart); err != nil {
+			return false, err
+		}
+		var buf [4]byte
+		n, err := io.ReadFull(r, buf[:])
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		sig = buf[:n]
+	} else {
+		sig = header[:n]
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return bytes.HasPrefix(sig, []byte("fLaC")), nil
+}
@@ -0,0 +1,38 @@
+package hidx_audio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsFLAC(t *testing.T) {
+	id3 := func(body string) string {
+		return "ID3\x04\x00\x00\x00\x00\x00" + string(rune(len(body))) + body
+	}
+	tcs := []struct {
+		Data string
+		Yes  bool
+	}{
+		{"fLaC\x00\x00\x00\x22", true},
+		{id3("tags") + "fLaC\x00", true},
+		{id3("") + "fLaC", true},
+		{"", false},
+		{"fLa", false},
+		{id3("tags"), false},
+		{id3("tags") + "\xff\xfb", false},
+		{"RIFF....WAVE", false},
+	}
+	for i, tc := range tcs {
+		r := bytes.NewReader([]byte(tc.Data))
+		yes, err := IsFLAC(r)
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, tc.Yes, yes, "test case %d", i)
+		// the reader is left at the start
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, tc.Data, string(data), "test case %d", i)
+	}
+}
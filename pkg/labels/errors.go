@@ -2,6 +2,21 @@ package labels
 
 import "github.com/pkg/errors"
 
+var (
+	// EOS is returned by iterators when there are no more elements
+	EOS = errors.New("end of stream")
+	// ErrNotFound is returned by QueryBackend.GetValue when a label does not exist
+	ErrNotFound = errors.New("label not found")
+)
+
+func IsEOS(err error) bool {
+	return errors.Is(err, EOS)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
 func errInvalidOp(op PredicateOp) error {
 	return errors.Errorf("invalid predicate op %v", op)
 }
@@ -0,0 +1,176 @@
+package labels
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+)
+
+var _ QueryBackend = &Federated{}
+
+// Federated is a QueryBackend which presents several named backends as a single index.
+//
+// Keys can be qualified with the name of a backend as "<name>.<key>", which restricts them to that backend.
+// An unqualified key refers to the value from the first backend, in name order, which has that key for the object.
+// Each object has at most one value for a key, so the results of a query are deduplicated.
+type Federated struct {
+	names    []string
+	backends []QueryBackend
+}
+
+func NewFederated(backends map[string]QueryBackend) *Federated {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	f := &Federated{names: names}
+	for _, name := range names {
+		f.backends = append(f.backends, backends[name])
+	}
+	return f
+}
+
+func (f *Federated) IterateForward(ctx context.Context, span Span) EntryIterator {
+	its := make([]EntryIterator, len(f.backends))
+	for i, be := range f.backends {
+		its[i] = be.IterateForward(ctx, span)
+	}
+	return &mergeIterator{
+		its: its,
+		cmp: func(a, b *Entry) int {
+			if c := bytes.Compare(a.ID[:], b.ID[:]); c != 0 {
+				return c
+			}
+			return bytes.Compare(a.Key, b.Key)
+		},
+	}
+}
+
+func (f *Federated) IterateInverted(ctx context.Context, tagKey string, span Span) EntryIterator {
+	if be, key, ok := f.qualified(tagKey); ok {
+		return be.IterateInverted(ctx, key, span)
+	}
+	its := make([]EntryIterator, len(f.backends))
+	for i, be := range f.backends {
+		its[i] = be.IterateInverted(ctx, tagKey, span)
+	}
+	return &mergeIterator{
+		its: its,
+		cmp: func(a, b *Entry) int {
+			if c := bytes.Compare(a.Value, b.Value); c != 0 {
+				return c
+			}
+			return bytes.Compare(a.ID[:], b.ID[:])
+		},
+		shadowed: func(ctx context.Context, i int, ent *Entry) (bool, error) {
+			for _, be := range f.backends[:i] {
+				if _, err := be.GetValue(ctx, ent.ID, tagKey); err == nil {
+					return true, nil
+				} else if !IsNotFound(err) {
+					return false, err
+				}
+			}
+			return false, nil
+		},
+	}
+}
+
+func (f *Federated) GetValue(ctx context.Context, id ID, tagKey string) ([]byte, error) {
+	if be, key, ok := f.qualified(tagKey); ok {
+		return be.GetValue(ctx, id, key)
+	}
+	for _, be := range f.backends {
+		v, err := be.GetValue(ctx, id, tagKey)
+		if IsNotFound(err) {
+			continue
+		}
+		return v, err
+	}
+	return nil, ErrNotFound
+}
+
+// qualified returns the backend and unqualified key, if tagKey is qualified with the name of a backend.
+func (f *Federated) qualified(tagKey string) (QueryBackend, string, bool) {
+	parts := strings.SplitN(tagKey, ".", 2)
+	if len(parts) != 2 {
+		return nil, "", false
+	}
+	i := sort.SearchStrings(f.names, parts[0])
+	if i >= len(f.names) || f.names[i] != parts[0] {
+		return nil, "", false
+	}
+	return f.backends[i], parts[1], true
+}
+
+// mergeIterator merges sorted EntryIterators into a single sorted stream.
+// Equal entries are only emitted once, from the first iterator containing them.
+type mergeIterator struct {
+	its []EntryIterator
+	cmp func(a, b *Entry) int
+	// shadowed, if set, is called to determine if an entry from its[i] should be skipped.
+	shadowed func(ctx context.Context, i int, ent *Entry) (bool, error)
+
+	heads []Entry
+	valid []bool
+}
+
+func (it *mergeIterator) Next(ctx context.Context, dst *Entry) error {
+	if it.heads == nil {
+		it.heads = make([]Entry, len(it.its))
+		it.valid = make([]bool, len(it.its))
+		for i := range it.its {
+			if err := it.advance(ctx, i); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		min := -1
+		for i := range it.heads {
+			if it.valid[i] && (min < 0 || it.cmp(&it.heads[i], &it.heads[min]) < 0) {
+				min = i
+			}
+		}
+		if min < 0 {
+			return EOS
+		}
+		dst.ID = it.heads[min].ID
+		dst.Key = append(dst.Key[:0], it.heads[min].Key...)
+		dst.Value = append(dst.Value[:0], it.heads[min].Value...)
+		for i := min; i < len(it.heads); i++ {
+			if it.valid[i] && it.cmp(&it.heads[i], dst) == 0 {
+				if err := it.advance(ctx, i); err != nil {
+					return err
+				}
+			}
+		}
+		if it.shadowed != nil {
+			yes, err := it.shadowed(ctx, min, dst)
+			if err != nil {
+				return err
+			}
+			if yes {
+				continue
+			}
+		}
+		return nil
+	}
+}
+
+func (it *mergeIterator) advance(ctx context.Context, i int) error {
+	var ent Entry
+	if err := it.its[i].Next(ctx, &ent); err != nil {
+		if IsEOS(err) {
+			it.valid[i] = false
+			return nil
+		}
+		return err
+	}
+	it.heads[i].ID = ent.ID
+	it.heads[i].Key = append(it.heads[i].Key[:0], ent.Key...)
+	it.heads[i].Value = append(it.heads[i].Value[:0], ent.Value...)
+	it.valid[i] = true
+	return nil
+}
@@ -3,11 +3,15 @@ package labels
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"encoding/binary"
+	"math"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 
 	"github.com/brendoncarroll/go-state"
 	"github.com/brendoncarroll/hoard/pkg/hcorpus"
+	"github.com/pkg/errors"
 )
 
 type ID = hcorpus.ID
@@ -27,7 +31,11 @@ const (
 	OpLt = PredicateOp("<")
 	OpGt = PredicateOp(">")
 
+	OpExists  = PredicateOp("EXISTS")
+	OpMissing = PredicateOp("MISSING")
+
 	OpContains = PredicateOp("CONTAINS")
+	OpPrefix   = PredicateOp("PREFIX")
 	OpRegexp   = PredicateOp("REGEXP")
 
 	OpIn = PredicateOp("IN")
@@ -36,6 +44,10 @@ const (
 	OpAND = PredicateOp("AND")
 )
 
+// Query is a predicate, and a limit on the number of results.
+// A Limit of 0 means there is no limit.
+// Limits have the same meaning at every level of the tree: a sub-query
+// contributes at most Limit IDs to its parent.
 type Query struct {
 	Where Predicate `json:"where"`
 	Limit int       `json:"limit"`
@@ -50,171 +62,638 @@ type Predicate struct {
 	Values     []string `json:"values,omitempty"`
 	SubQueries []Query  `json:"sub_queries,omitempty"`
 
+	// CaseInsensitive applies to OpEq, OpContains and OpPrefix
+	CaseInsensitive bool `json:"case_insensitive,omitempty"`
+
+	// Limit is applied in addition to the limit of the Query containing the Predicate.
 	Limit int `json:"limit"`
 }
 
-var ErrStopIter = fmt.Errorf("stop iteration")
+// MarshalQuery returns a canonical encoding of q.
+// Queries which differ only in how defaults and limits are expressed have the same encoding.
+//
+// The fields of the predicate are encoded in order, followed by the limit of the query.
+// Strings are prefixed with their length, and lists with their number of elements, as uvarints.
+func MarshalQuery(q Query) []byte {
+	return appendQuery(nil, canonicalQuery(q))
+}
+
+// ParseQuery parses a query encoded with MarshalQuery.
+func ParseQuery(data []byte) (*Query, error) {
+	r := &queryReader{data: data}
+	q := r.readQuery(0)
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errors.Errorf("%d extra bytes after query", len(r.data))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &q, nil
+}
+
+// maxQueryDepth is the deepest nesting of sub-queries which ParseQuery accepts.
+const maxQueryDepth = 64
+
+func appendQuery(out []byte, q Query) []byte {
+	out = appendString(out, string(q.Where.Op))
+	out = appendString(out, q.Where.Key)
+	out = appendString(out, q.Where.Value)
+	out = appendUvarint(out, uint64(len(q.Where.Values)))
+	for _, v := range q.Where.Values {
+		out = appendString(out, v)
+	}
+	out = appendUvarint(out, uint64(len(q.Where.SubQueries)))
+	for _, sq := range q.Where.SubQueries {
+		out = appendQuery(out, sq)
+	}
+	if q.Where.CaseInsensitive {
+		out = append(out, 1)
+	} else {
+		out = append(out, 0)
+	}
+	return appendUvarint(out, uint64(q.Limit))
+}
+
+func appendString(out []byte, x string) []byte {
+	out = appendUvarint(out, uint64(len(x)))
+	return append(out, x...)
+}
+
+func appendUvarint(out []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(out, buf[:n]...)
+}
+
+// queryReader parses the encoding produced by MarshalQuery.
+// The first error is kept in err, and later reads return zero values.
+type queryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *queryReader) readQuery(depth int) (q Query) {
+	if depth > maxQueryDepth && r.err == nil {
+		r.err = errors.Errorf("query is nested more than %d deep", maxQueryDepth)
+	}
+	q.Where.Op = PredicateOp(r.readString())
+	q.Where.Key = r.readString()
+	q.Where.Value = r.readString()
+	if n := r.readCount(); n > 0 {
+		q.Where.Values = make([]string, n)
+		for i := range q.Where.Values {
+			q.Where.Values[i] = r.readString()
+		}
+	}
+	if n := r.readCount(); n > 0 {
+		q.Where.SubQueries = make([]Query, n)
+		for i := range q.Where.SubQueries {
+			q.Where.SubQueries[i] = r.readQuery(depth + 1)
+		}
+	}
+	switch b := r.readByte(); b {
+	case 0:
+	case 1:
+		q.Where.CaseInsensitive = true
+	default:
+		if r.err == nil {
+			r.err = errors.Errorf("invalid bool %d in query", b)
+		}
+	}
+	q.Limit = int(r.readUvarint())
+	if r.err != nil {
+		return Query{}
+	}
+	return q
+}
+
+func (r *queryReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 1 {
+		r.err = errors.New("unexpected end of query")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *queryReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 || x > math.MaxInt32 {
+		r.err = errors.New("invalid uvarint in query")
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
 
-type IterFunc = func(id ID, key, value []byte) error
+// readCount reads the number of elements in a list, each of which is at least 1 byte.
+func (r *queryReader) readCount() uint64 {
+	n := r.readUvarint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = errors.New("unexpected end of query")
+		return 0
+	}
+	return n
+}
+
+func (r *queryReader) readString() string {
+	n := r.readUvarint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = errors.New("unexpected end of query")
+	}
+	if r.err != nil {
+		return ""
+	}
+	x := string(r.data[:n])
+	r.data = r.data[n:]
+	return x
+}
+
+func canonicalQuery(q Query) Query {
+	if q.Where.Op == PredicateOp("") {
+		q.Where.Op = OpAny
+	}
+	q.Limit, q.Where.Limit = queryLimit(q), 0
+	if q.Limit < 0 {
+		q.Limit = 0
+	}
+	if q.Where.Op == OpIn {
+		q.Where.Values = append([]string{}, q.Where.Values...)
+		sort.Strings(q.Where.Values)
+	}
+	if len(q.Where.SubQueries) > 0 {
+		subs := make([]Query, len(q.Where.SubQueries))
+		for i := range subs {
+			subs[i] = canonicalQuery(q.Where.SubQueries[i])
+		}
+		q.Where.SubQueries = subs
+	}
+	return q
+}
 
 type Span = state.ByteSpan
 
+// Entry is a single label on an object, as stored in an index.
+type Entry struct {
+	ID    ID
+	Key   []byte
+	Value []byte
+}
+
+// EntryIterator is a stream of Entries.
+// The Key and Value written to ent are only valid until the next call to Next.
+type EntryIterator interface {
+	Next(ctx context.Context, ent *Entry) error
+}
+
 type QueryBackend interface {
-	ScanForward(ctx context.Context, span Span, fn IterFunc) error
-	ScanInverted(ctx context.Context, tagKey string, fn IterFunc) error
+	// IterateForward returns the entries in the forward index, ordered by ID then key.
+	// span is applied to the concatenation of the ID and key.
+	IterateForward(ctx context.Context, span Span) EntryIterator
+	// IterateInverted returns the entries for tagKey, ordered by value then ID.
+	// span is applied to the value.
+	IterateInverted(ctx context.Context, tagKey string, span Span) EntryIterator
+	// GetValue returns the value of the label with tagKey on the object id.
+	// GetValue returns ErrNotFound if there is no such label.
 	GetValue(ctx context.Context, id ID, tagKey string) ([]byte, error)
 }
 
-func DoQuery(ctx context.Context, be QueryBackend, q Query) (*ResultSet, error) {
+// Iterator is a stream of IDs.
+type Iterator interface {
+	// Next writes the next ID to dst, or returns EOS.
+	Next(ctx context.Context, dst *ID) error
+}
+
+// Iterate returns an Iterator over the IDs matching q.
+// Each ID is emitted at most once.
+// Memory used by the Iterator is bounded by the limits on the sub-queries in q, not the size of the index.
+func Iterate(be QueryBackend, q Query) (Iterator, error) {
 	if q.Where.Op == PredicateOp("") {
 		q.Where.Op = OpAny
 	}
-
-	ids := map[ID]int{}
-	if err := query(ctx, be, ids, q, false); err != nil {
+	it, err := newIterator(be, q.Where)
+	if err != nil {
 		return nil, err
 	}
+	if limit := queryLimit(q); limit > 0 {
+		it = &limitIterator{it: it, remaining: limit}
+	}
+	return it, nil
+}
 
-	resultSet := &ResultSet{
-		IDs:    make([]ID, 0, len(ids)),
+// DoQuery collects the IDs matching q into a ResultSet.
+func DoQuery(ctx context.Context, be QueryBackend, q Query) (*ResultSet, error) {
+	it, err := Iterate(be, q)
+	if err != nil {
+		return nil, err
+	}
+	var ids []ID
+	var id ID
+	for {
+		if err := it.Next(ctx, &id); err != nil {
+			if IsEOS(err) {
+				break
+			}
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return &ResultSet{
+		IDs:    ids,
 		Count:  len(ids),
 		Offset: 0,
 		Total:  -1,
-	}
-	for id := range ids {
-		resultSet.IDs = append(resultSet.IDs, id)
-	}
-	return resultSet, nil
+	}, nil
 }
 
-func query(ctx context.Context, be QueryBackend, ids map[ID]int, q Query, pruning bool) error {
-	switch q.Where.Op {
+func newIterator(be QueryBackend, pred Predicate) (Iterator, error) {
+	switch pred.Op {
 	case OpOR:
-		ids2 := map[ID]int{}
-		if err := queryOR(ctx, be, ids2, q.Limit, q.Where.SubQueries); err != nil {
-			return err
-		}
-		count := 0
-		for id := range ids2 {
-			if count >= q.Limit {
-				break
+		its := make([]Iterator, len(pred.SubQueries))
+		filters := make([]filter, len(pred.SubQueries))
+		for i, q := range pred.SubQueries {
+			var err error
+			if its[i], err = Iterate(be, q); err != nil {
+				return nil, err
+			}
+			if filters[i], err = newFilter(be, q); err != nil {
+				return nil, err
 			}
-			ids[id]++
-			count++
 		}
+		return &orIterator{its: its, filters: filters}, nil
 	case OpAND:
-		ids2 := map[ID]int{}
-		if err := queryAND(ctx, be, ids, q.Where.SubQueries); err != nil {
-			return err
+		if len(pred.SubQueries) == 0 {
+			return emptyIterator{}, nil
 		}
-		count := 0
-		for id := range ids2 {
-			if count >= q.Limit {
-				break
+		it, err := Iterate(be, pred.SubQueries[0])
+		if err != nil {
+			return nil, err
+		}
+		var filters []filter
+		for _, q := range pred.SubQueries[1:] {
+			f, err := newFilter(be, q)
+			if err != nil {
+				return nil, err
 			}
-			ids[id]++
-			count++
+			filters = append(filters, f)
 		}
+		return &andIterator{it: it, filters: filters}, nil
 	case OpAny:
-		err := be.ScanForward(ctx, Span{}, func(id ID, _, value []byte) error {
-			ids[id]++
-			if len(ids) > q.Limit {
-				return ErrStopIter
-			}
-			return nil
-		})
-		if err == ErrStopIter {
-			err = nil
+		return &forwardIterator{be: be}, nil
+	case OpNone:
+		return emptyIterator{}, nil
+	case OpMissing:
+		// anti-join: every ID in the forward index, without a value for the key
+		missing, err := newPredicateFilter(be, pred)
+		if err != nil {
+			return nil, err
 		}
-		return err
+		return &andIterator{it: &forwardIterator{be: be}, filters: []filter{missing}}, nil
+	default:
+		predFunc, err := makePredicateFunc(pred)
+		if err != nil {
+			return nil, err
+		}
+		return &invertedIterator{
+			be:       be,
+			key:      pred.Key,
+			span:     valueSpan(pred),
+			predFunc: predFunc,
+		}, nil
+	}
+}
 
+// queryLimit returns the number of IDs q can produce, or 0 if it is unlimited.
+func queryLimit(q Query) int {
+	a, b := q.Limit, q.Where.Limit
+	switch {
+	case a <= 0:
+		return b
+	case b <= 0 || a < b:
+		return a
 	default:
-		if pruning {
-			return scanResults(ctx, be, ids, q.Where, func(id ID) bool {
-				ids[id]++
-				return len(ids) < 1
-			})
-		} else {
-			return scanTable(ctx, be, q.Where, func(id ID) bool {
-				ids[id]++
-				return len(ids) < q.Limit
-			})
+		return b
+	}
+}
+
+// valueSpan returns the span of values which could satisfy pred.
+// Values cannot contain NULL, so the value immediately after v is v + 0x01
+func valueSpan(pred Predicate) Span {
+	v := []byte(pred.Value)
+	if pred.CaseInsensitive {
+		return Span{}
+	}
+	switch pred.Op {
+	case OpEq:
+		return Span{Begin: v, End: append(append([]byte{}, v...), 0x01)}
+	case OpLt:
+		return Span{End: v}
+	case OpGt:
+		return Span{Begin: append(append([]byte{}, v...), 0x01)}
+	case OpPrefix:
+		return prefixSpan(v)
+	case OpRegexp:
+		if prefix := regexpPrefix(pred.Value); prefix != "" {
+			return prefixSpan([]byte(prefix))
+		}
+		return Span{}
+	default:
+		return Span{}
+	}
+}
+
+// regexpPrefix returns a literal prefix of every value matching expr,
+// or the empty string if expr is not anchored to the beginning of the value.
+func regexpPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	var prefix []rune
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
 		}
+		prefix = append(prefix, sub.Rune...)
 	}
+	return string(prefix)
+}
+
+type emptyIterator struct{}
+
+func (emptyIterator) Next(ctx context.Context, dst *ID) error {
+	return EOS
+}
+
+type limitIterator struct {
+	it        Iterator
+	remaining int
+}
+
+func (it *limitIterator) Next(ctx context.Context, dst *ID) error {
+	if it.remaining <= 0 {
+		return EOS
+	}
+	if err := it.it.Next(ctx, dst); err != nil {
+		return err
+	}
+	it.remaining--
 	return nil
 }
 
-func queryAND(ctx context.Context, be QueryBackend, ids map[ID]int, subs []Query) error {
-	round := 0
-	for _, q := range subs {
-		if err := query(ctx, be, ids, q, round == 0); err != nil {
+// forwardIterator emits every ID in the forward index.
+type forwardIterator struct {
+	be QueryBackend
+
+	it      EntryIterator
+	ent     Entry
+	last    ID
+	started bool
+}
+
+func (it *forwardIterator) Next(ctx context.Context, dst *ID) error {
+	if it.it == nil {
+		it.it = it.be.IterateForward(ctx, Span{})
+	}
+	for {
+		if err := it.it.Next(ctx, &it.ent); err != nil {
 			return err
 		}
-		round++
-		for id, count := range ids {
-			if count < round {
-				delete(ids, id)
-			}
+		// entries are ordered by ID, so duplicates are adjacent.
+		if it.started && it.last == it.ent.ID {
+			continue
 		}
+		it.last = it.ent.ID
+		it.started = true
+		*dst = it.ent.ID
+		return nil
 	}
-	return nil
 }
 
-func queryOR(ctx context.Context, be QueryBackend, ids map[ID]int, limit int, subs []Query) error {
-	for _, q := range subs {
-		if err := query(ctx, be, ids, q, false); err != nil {
+// invertedIterator emits the IDs with a value for key satisfying predFunc.
+type invertedIterator struct {
+	be       QueryBackend
+	key      string
+	span     Span
+	predFunc func([]byte) bool
+
+	it  EntryIterator
+	ent Entry
+}
+
+func (it *invertedIterator) Next(ctx context.Context, dst *ID) error {
+	if it.it == nil {
+		it.it = it.be.IterateInverted(ctx, it.key, it.span)
+	}
+	for {
+		if err := it.it.Next(ctx, &it.ent); err != nil {
 			return err
 		}
-		if len(ids) >= limit {
-			break
+		if it.predFunc(it.ent.Value) {
+			*dst = it.ent.ID
+			return nil
 		}
 	}
-	return nil
 }
 
-func scanResults(ctx context.Context, be QueryBackend, ids map[ID]int, pred Predicate, fn func(id ID) bool) error {
-	predFunc, err := makePredicateFunc(pred)
-	if err != nil {
-		return err
-	}
-	for id := range ids {
-		value, err := be.GetValue(ctx, id, pred.Key)
-		if err != nil {
+// orIterator emits the IDs from each of its iterators in turn.
+// An ID is skipped if it matches an earlier sub-query, so that it is only emitted once.
+type orIterator struct {
+	its     []Iterator
+	filters []filter
+	index   int
+}
+
+func (it *orIterator) Next(ctx context.Context, dst *ID) error {
+	for it.index < len(it.its) {
+		if err := it.its[it.index].Next(ctx, dst); err != nil {
+			if IsEOS(err) {
+				it.index++
+				continue
+			}
 			return err
 		}
-		if predFunc(value) {
-			if !fn(id) {
+		seen := false
+		for _, f := range it.filters[:it.index] {
+			yes, err := f(ctx, *dst)
+			if err != nil {
+				return err
+			}
+			if yes {
+				seen = true
 				break
 			}
 		}
+		if !seen {
+			return nil
+		}
 	}
-	return nil
+	return EOS
 }
 
-func scanTable(ctx context.Context, be QueryBackend, pred Predicate, fn func(id ID) bool) error {
-	switch pred.Op {
-	case OpEq, OpLt, OpGt, OpContains, OpIn, OpAny:
-		predFunc, err := makePredicateFunc(pred)
+// andIterator emits the IDs from it which pass every filter.
+type andIterator struct {
+	it      Iterator
+	filters []filter
+}
+
+func (it *andIterator) Next(ctx context.Context, dst *ID) error {
+	for {
+		if err := it.it.Next(ctx, dst); err != nil {
+			return err
+		}
+		pass, err := checkAll(ctx, it.filters, *dst)
 		if err != nil {
 			return err
 		}
-		err = be.ScanInverted(ctx, pred.Key, func(id ID, _, value []byte) error {
-			if predFunc(value) {
-				if !fn(id) {
-					return ErrStopIter
+		if pass {
+			return nil
+		}
+	}
+}
+
+// filter returns true if the ID is in the results of a query
+type filter = func(ctx context.Context, id ID) (bool, error)
+
+// newFilter returns a filter for the results of q.
+// Unlimited queries are checked directly against the forward index.
+// The results of limited queries depend on the order of iteration, so they are collected,
+// up to the limit, the first time the filter is called.
+func newFilter(be QueryBackend, q Query) (filter, error) {
+	if q.Where.Op == PredicateOp("") {
+		q.Where.Op = OpAny
+	}
+	if queryLimit(q) > 0 {
+		it, err := Iterate(be, q)
+		if err != nil {
+			return nil, err
+		}
+		var set map[ID]struct{}
+		// the iterator cannot be rewound, so an error while collecting the set is returned from every call.
+		var setErr error
+		return func(ctx context.Context, id ID) (bool, error) {
+			if setErr != nil {
+				return false, setErr
+			}
+			if set == nil {
+				set2 := make(map[ID]struct{})
+				var id2 ID
+				for {
+					if err := it.Next(ctx, &id2); err != nil {
+						if IsEOS(err) {
+							break
+						}
+						setErr = err
+						return false, err
+					}
+					set2[id2] = struct{}{}
 				}
+				set = set2
+			}
+			_, yes := set[id]
+			return yes, nil
+		}, nil
+	}
+	return newPredicateFilter(be, q.Where)
+}
+
+func newPredicateFilter(be QueryBackend, pred Predicate) (filter, error) {
+	switch pred.Op {
+	case OpOR, OpAND:
+		filters := make([]filter, len(pred.SubQueries))
+		for i, q := range pred.SubQueries {
+			var err error
+			if filters[i], err = newFilter(be, q); err != nil {
+				return nil, err
 			}
-			return nil
-		})
-		if err == ErrStopIter {
-			err = nil
 		}
-		return err
+		if pred.Op == OpAND {
+			return func(ctx context.Context, id ID) (bool, error) {
+				if len(filters) == 0 {
+					return false, nil
+				}
+				return checkAll(ctx, filters, id)
+			}, nil
+		}
+		return func(ctx context.Context, id ID) (bool, error) {
+			for _, f := range filters {
+				yes, err := f(ctx, id)
+				if err != nil || yes {
+					return yes, err
+				}
+			}
+			return false, nil
+		}, nil
+	case OpAny:
+		return func(ctx context.Context, id ID) (bool, error) {
+			it := be.IterateForward(ctx, prefixSpan(id[:]))
+			var ent Entry
+			if err := it.Next(ctx, &ent); err != nil {
+				if IsEOS(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return true, nil
+		}, nil
 	case OpNone:
-		return nil
+		return func(context.Context, ID) (bool, error) { return false, nil }, nil
+	case OpMissing:
+		return func(ctx context.Context, id ID) (bool, error) {
+			_, err := be.GetValue(ctx, id, pred.Key)
+			if IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}, nil
 	default:
-		return errInvalidOp(pred.Op)
+		predFunc, err := makePredicateFunc(pred)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, id ID) (bool, error) {
+			value, err := be.GetValue(ctx, id, pred.Key)
+			if err != nil {
+				if IsNotFound(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return predFunc(value), nil
+		}, nil
+	}
+}
+
+func prefixSpan(prefix []byte) Span {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return Span{Begin: prefix, End: end[:i+1]}
+		}
+	}
+	return Span{Begin: prefix}
+}
+
+func checkAll(ctx context.Context, filters []filter, id ID) (bool, error) {
+	for _, f := range filters {
+		yes, err := f(ctx, id)
+		if err != nil || !yes {
+			return false, err
+		}
 	}
+	return true, nil
 }
 
 func makePredicateFunc(pred Predicate) (func([]byte) bool, error) {
@@ -222,8 +701,14 @@ func makePredicateFunc(pred Predicate) (func([]byte) bool, error) {
 	var fn func([]byte) bool
 	switch pred.Op {
 	case OpEq:
-		fn = func(value []byte) bool {
-			return bytes.Equal(value, target)
+		if pred.CaseInsensitive {
+			fn = func(value []byte) bool {
+				return bytes.EqualFold(value, target)
+			}
+		} else {
+			fn = func(value []byte) bool {
+				return bytes.Equal(value, target)
+			}
 		}
 	case OpLt:
 		fn = func(value []byte) bool {
@@ -234,8 +719,25 @@ func makePredicateFunc(pred Predicate) (func([]byte) bool, error) {
 			return bytes.Compare(value, target) > 0
 		}
 	case OpContains:
-		fn = func(value []byte) bool {
-			return bytes.Contains(value, target)
+		if pred.CaseInsensitive {
+			target = bytes.ToLower(target)
+			fn = func(value []byte) bool {
+				return bytes.Contains(bytes.ToLower(value), target)
+			}
+		} else {
+			fn = func(value []byte) bool {
+				return bytes.Contains(value, target)
+			}
+		}
+	case OpPrefix:
+		if pred.CaseInsensitive {
+			fn = func(value []byte) bool {
+				return len(value) >= len(target) && bytes.EqualFold(value[:len(target)], target)
+			}
+		} else {
+			fn = func(value []byte) bool {
+				return bytes.HasPrefix(value, target)
+			}
 		}
 	case OpIn:
 		fn = func(value []byte) bool {
@@ -246,7 +748,7 @@ func makePredicateFunc(pred Predicate) (func([]byte) bool, error) {
 			}
 			return false
 		}
-	case OpAny:
+	case OpAny, OpExists:
 		fn = func([]byte) bool { return true }
 	case OpNone:
 		fn = func([]byte) bool { return false }
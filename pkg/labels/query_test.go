@@ -0,0 +1,117 @@
+package labels
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSpan(t *testing.T) {
+	tcs := []struct {
+		Pred Predicate
+		Span Span
+	}{
+		{Predicate{Op: OpEq, Value: "abc"}, Span{Begin: []byte("abc"), End: []byte("abc\x01")}},
+		{Predicate{Op: OpPrefix, Value: "abc"}, Span{Begin: []byte("abc"), End: []byte("abd")}},
+		{Predicate{Op: OpPrefix, Value: "abc", CaseInsensitive: true}, Span{}},
+		{Predicate{Op: OpRegexp, Value: "^abc"}, Span{Begin: []byte("abc"), End: []byte("abd")}},
+		{Predicate{Op: OpRegexp, Value: "^abc.*x"}, Span{Begin: []byte("abc"), End: []byte("abd")}},
+		{Predicate{Op: OpRegexp, Value: `^a\.b`}, Span{Begin: []byte("a.b"), End: []byte("a.c")}},
+		{Predicate{Op: OpRegexp, Value: "abc"}, Span{}},
+		{Predicate{Op: OpRegexp, Value: "^(?i)abc"}, Span{}},
+		{Predicate{Op: OpRegexp, Value: "^ab|cd"}, Span{}},
+	}
+	for i, tc := range tcs {
+		require.Equal(t, tc.Span, valueSpan(tc.Pred), "test case %d", i)
+	}
+}
+
+func TestMarshalQuery(t *testing.T) {
+	a := Query{
+		Where: Predicate{Op: OpIn, Key: "k", Values: []string{"b", "a"}, Limit: 10},
+	}
+	b := Query{
+		Where: Predicate{Op: OpIn, Key: "k", Values: []string{"a", "b"}},
+		Limit: 10,
+	}
+	require.Equal(t, string(MarshalQuery(a)), string(MarshalQuery(b)))
+	require.Equal(t, string(MarshalQuery(Query{})), string(MarshalQuery(Query{Where: Predicate{Op: OpAny}})))
+	require.NotEqual(t, string(MarshalQuery(a)), string(MarshalQuery(Query{Where: b.Where})))
+}
+
+func TestParseQuery(t *testing.T) {
+	qs := []Query{
+		{},
+		{Where: Predicate{Op: OpEq, Key: "k", Value: "v", CaseInsensitive: true}, Limit: 3},
+		{Where: Predicate{Op: OpAND, SubQueries: []Query{
+			{Where: Predicate{Op: OpIn, Key: "k", Values: []string{"a", "b"}}},
+			{Where: Predicate{Op: OpRegexp, Key: "k", Value: "^x"}, Limit: 7},
+		}}},
+	}
+	for i, q := range qs {
+		data := MarshalQuery(q)
+		q2, err := ParseQuery(data)
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, data, MarshalQuery(*q2), "test case %d", i)
+
+		_, err = ParseQuery(data[:len(data)-1])
+		require.Error(t, err, "test case %d", i)
+		_, err = ParseQuery(append(data, 0))
+		require.Error(t, err, "test case %d", i)
+	}
+}
+
+func TestFilterError(t *testing.T) {
+	ctx := context.Background()
+	ids := []ID{{1}, {2}, {3}}
+	be := &flakyBackend{ids: ids, failAt: 1}
+	f, err := newFilter(be, Query{Limit: 10})
+	require.NoError(t, err)
+	_, err = f(ctx, ids[2])
+	require.Error(t, err)
+	// the iterator has been partly consumed, so later calls must not return an incomplete set.
+	_, err = f(ctx, ids[2])
+	require.Error(t, err)
+}
+
+// flakyBackend has a label on each of ids.
+// Its forward iterators fail once, before the entry at failAt.
+type flakyBackend struct {
+	ids    []ID
+	failAt int
+	failed bool
+}
+
+func (be *flakyBackend) IterateForward(ctx context.Context, span Span) EntryIterator {
+	var i int
+	return entryIteratorFunc(func(ctx context.Context, ent *Entry) error {
+		if i == be.failAt && !be.failed {
+			be.failed = true
+			return errors.New("flaky backend")
+		}
+		if i >= len(be.ids) {
+			return EOS
+		}
+		*ent = Entry{ID: be.ids[i], Key: []byte("k"), Value: []byte("v")}
+		i++
+		return nil
+	})
+}
+
+func (be *flakyBackend) IterateInverted(ctx context.Context, tagKey string, span Span) EntryIterator {
+	return entryIteratorFunc(func(context.Context, *Entry) error {
+		return EOS
+	})
+}
+
+func (be *flakyBackend) GetValue(ctx context.Context, id ID, tagKey string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+type entryIteratorFunc func(ctx context.Context, ent *Entry) error
+
+func (f entryIteratorFunc) Next(ctx context.Context, ent *Entry) error {
+	return f(ctx, ent)
+}
@@ -0,0 +1,151 @@
+// Package signedcell provides a cell which signs the contents of another cell with ed25519,
+// so that readers can check that the contents were written by the holder of a private key.
+package signedcell
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brendoncarroll/go-state/cells"
+)
+
+// sigPurpose is signed along with the contents, so the signatures cannot be used for anything else.
+const sigPurpose = "hoard/signedcell\x00"
+
+// ErrReadOnly is returned by CAS on a Cell without a private key.
+var ErrReadOnly = errors.New("signedcell: cell is read-only, it has no private key")
+
+// ErrBadSignature is returned when the contents of the inner cell are not signed by the public key.
+type ErrBadSignature struct {
+	Unsigned bool
+}
+
+func (e ErrBadSignature) Error() string {
+	if e.Unsigned {
+		return "signedcell: contents are not signed"
+	}
+	return "signedcell: contents have an invalid signature"
+}
+
+// Cell stores its contents in an inner cell, prefixed with an ed25519 signature.
+// Writers read an empty inner cell as empty, so they can write the first contents.
+// Read-only Cells require everything to be signed, including empty contents, so that
+// clearing the inner cell cannot be used to roll back the contents; see NewReadOnly.
+type Cell struct {
+	inner   cells.Cell
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+// New returns a Cell which signs the contents with private, and checks them with its public key.
+func New(inner cells.Cell, private ed25519.PrivateKey) *Cell {
+	return &Cell{
+		inner:   inner,
+		public:  private.Public().(ed25519.PublicKey),
+		private: private,
+	}
+}
+
+// NewReadOnly returns a Cell which checks the contents with public.
+// CAS always returns ErrReadOnly.
+// Reading an empty inner cell returns ErrBadSignature, until a writer has written to it,
+// which can be an empty write, to publish signed empty contents.
+func NewReadOnly(inner cells.Cell, public ed25519.PublicKey) *Cell {
+	return &Cell{
+		inner:  inner,
+		public: public,
+	}
+}
+
+// ParsePublicKey parses a hex encoded public key.
+func ParsePublicKey(x string) (ed25519.PublicKey, error) {
+	data, err := hex.DecodeString(x)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d hex characters", 2*ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// ReadPrivateKeyFile reads a private key from the file at p.
+// The file must contain the seed of the key, as raw bytes or hex.
+func ReadPrivateKeyFile(p string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.SeedSize {
+		data, err = hex.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil || len(data) != ed25519.SeedSize {
+			return nil, fmt.Errorf("private key file %s must contain %d bytes, or %d hex characters", p, ed25519.SeedSize, 2*ed25519.SeedSize)
+		}
+	}
+	return ed25519.NewKeyFromSeed(data), nil
+}
+
+func (c *Cell) Read(ctx context.Context, buf []byte) (int, error) {
+	msg, err := cells.GetBytes(ctx, c.inner)
+	if err != nil {
+		return 0, err
+	}
+	return c.open(buf, msg)
+}
+
+func (c *Cell) CAS(ctx context.Context, actual, prev, next []byte) (bool, int, error) {
+	if c.private == nil {
+		return false, 0, ErrReadOnly
+	}
+	if len(next) > c.MaxSize() {
+		return false, 0, cells.ErrTooLarge{}
+	}
+	msg, err := cells.GetBytes(ctx, c.inner)
+	if err != nil {
+		return false, 0, err
+	}
+	n, err := c.open(actual, msg)
+	if err != nil {
+		return false, 0, err
+	}
+	if !bytes.Equal(actual[:n], prev) {
+		return false, n, nil
+	}
+	msg2 := make([]byte, 0, ed25519.SignatureSize+len(next))
+	msg2 = append(msg2, ed25519.Sign(c.private, makeSigData(next))...)
+	msg2 = append(msg2, next...)
+	buf := make([]byte, c.inner.MaxSize())
+	swapped, n, err := c.inner.CAS(ctx, buf, msg, msg2)
+	if err != nil {
+		return false, 0, err
+	}
+	n, err = c.open(actual, buf[:n])
+	return swapped, n, err
+}
+
+func (c *Cell) MaxSize() int {
+	return c.inner.MaxSize() - ed25519.SignatureSize
+}
+
+func (c *Cell) open(dst, msg []byte) (int, error) {
+	if len(msg) == 0 && c.private != nil {
+		return 0, nil
+	}
+	if len(msg) < ed25519.SignatureSize {
+		return 0, ErrBadSignature{Unsigned: true}
+	}
+	sig, data := msg[:ed25519.SignatureSize], msg[ed25519.SignatureSize:]
+	if !ed25519.Verify(c.public, makeSigData(data), sig) {
+		return 0, ErrBadSignature{}
+	}
+	if len(dst) < len(data) {
+		return 0, errors.New("signedcell: buffer too short")
+	}
+	return copy(dst, data), nil
+}
+
+func makeSigData(data []byte) []byte {
+	return append([]byte(sigPurpose), data...)
+}
@@ -0,0 +1,83 @@
+package signedcell
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/brendoncarroll/go-state/cells"
+	"github.com/brendoncarroll/go-state/cells/celltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCell(t *testing.T) {
+	celltest.CellTestSuite(t, func(t testing.TB) cells.Cell {
+		return New(cells.NewMem(1<<16), newKey(1))
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	inner := cells.NewMem(1 << 16)
+	w := New(inner, newKey(1))
+	r := NewReadOnly(inner, newKey(1).Public().(ed25519.PublicKey))
+
+	// an empty inner cell is not signed, so it could have been cleared by anyone.
+	_, err := cells.GetBytes(ctx, r)
+	require.ErrorIs(t, err, ErrBadSignature{Unsigned: true})
+	// writing empty contents signs them.
+	require.NoError(t, cells.Apply(ctx, w, func([]byte) ([]byte, error) {
+		return []byte{}, nil
+	}))
+	data, err := cells.GetBytes(ctx, r)
+	require.NoError(t, err)
+	require.Len(t, data, 0)
+
+	require.NoError(t, cells.Apply(ctx, w, func([]byte) ([]byte, error) {
+		return []byte("state"), nil
+	}))
+	data, err = cells.GetBytes(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "state", string(data))
+	_, _, err = r.CAS(ctx, make([]byte, r.MaxSize()), data, []byte("state2"))
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	// tamper with the contents
+	msg, err := cells.GetBytes(ctx, inner)
+	require.NoError(t, err)
+	msg2 := append([]byte{}, msg...)
+	msg2[len(msg2)-1] ^= 1
+	require.NoError(t, cells.Apply(ctx, inner, func([]byte) ([]byte, error) {
+		return msg2, nil
+	}))
+	_, err = cells.GetBytes(ctx, r)
+	require.ErrorAs(t, err, &ErrBadSignature{})
+
+	// clearing the inner cell
+	require.NoError(t, cells.Apply(ctx, inner, func([]byte) ([]byte, error) {
+		return nil, nil
+	}))
+	_, err = cells.GetBytes(ctx, r)
+	require.ErrorIs(t, err, ErrBadSignature{Unsigned: true})
+
+	// another key
+	r2 := NewReadOnly(inner, newKey(2).Public().(ed25519.PublicKey))
+	require.NoError(t, cells.Apply(ctx, inner, func([]byte) ([]byte, error) {
+		return msg, nil
+	}))
+	_, err = cells.GetBytes(ctx, r2)
+	require.ErrorAs(t, err, &ErrBadSignature{})
+
+	// unsigned
+	require.NoError(t, cells.Apply(ctx, inner, func([]byte) ([]byte, error) {
+		return []byte("{}"), nil
+	}))
+	_, err = cells.GetBytes(ctx, r)
+	require.ErrorIs(t, err, ErrBadSignature{Unsigned: true})
+}
+
+func newKey(i byte) ed25519.PrivateKey {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = i
+	return ed25519.NewKeyFromSeed(seed)
+}